@@ -0,0 +1,10 @@
+package config
+
+import "os"
+
+// EnvAdminAPIKey is the key operational endpoints such as the circuit breaker state are
+// guarded with, when it is not configured those endpoints reject every request
+func EnvAdminAPIKey() string {
+	loadEnv()
+	return os.Getenv("admin_api_key")
+}
@@ -0,0 +1,41 @@
+package config
+
+import (
+	"encoding/base64"
+	"log"
+	"os"
+	"strings"
+)
+
+// TokenEncryptionKeys returns the key encryption keys used to protect spotify tokens at rest,
+// mapped by key id, together with the id of the key new records should be encrypted with.
+// Keys are configured as a comma separated list of id:base64key pairs, older keys are kept
+// in the list until every record has been rotated to the active key
+func TokenEncryptionKeys() (map[string][]byte, string) {
+	loadEnv()
+	rawKeys := os.Getenv("token_encryption_keys")
+	activeKeyId := os.Getenv("token_encryption_active_key")
+	keys := map[string][]byte{}
+	for _, entry := range strings.Split(rawKeys, ",") {
+		entry = strings.TrimSpace(entry)
+		if len(entry) == 0 {
+			continue
+		}
+		keyId, encodedKey, found := strings.Cut(entry, ":")
+		if !found || len(keyId) == 0 {
+			log.Fatalln("Malformed token encryption key entry, expected id:base64key")
+		}
+		key, err := base64.StdEncoding.DecodeString(encodedKey)
+		if err != nil {
+			log.Fatalln("Could not decode token encryption key " + keyId)
+		}
+		if len(key) != 16 && len(key) != 24 && len(key) != 32 {
+			log.Fatalln("Token encryption key " + keyId + " must be 16, 24 or 32 bytes long")
+		}
+		keys[keyId] = key
+	}
+	if _, ok := keys[activeKeyId]; !ok {
+		log.Fatalln("Active token encryption key is not configured")
+	}
+	return keys, activeKeyId
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"log"
 	"os"
+	"strconv"
 
 	"github.com/joho/godotenv"
 )
@@ -57,7 +58,29 @@ func EnvProfile() string {
 	return os.Getenv("profile")
 }
 
+// minJWTSecretLength is the size of the HS256 hash, shorter secrets are easier to brute force
+const minJWTSecretLength = 32
+
+// JWTSecret is the key our session tokens are signed with. Without one anybody could forge
+// a token, so a missing or short secret stops the app from starting
 func JWTSecret() string {
 	loadEnv()
-	return os.Getenv("jwt_secret")
+	secret := os.Getenv("jwt_secret")
+	if len(secret) == 0 {
+		log.Fatalln("jwt_secret is not configured")
+	}
+	if len(secret) < minJWTSecretLength {
+		log.Fatalln("jwt_secret must be at least " + strconv.Itoa(minJWTSecretLength) + " bytes long")
+	}
+	return secret
+}
+
+// EnvPlaylistJobWorkers is the number of workers processing background playlist jobs
+func EnvPlaylistJobWorkers() int {
+	loadEnv()
+	workers, err := strconv.Atoi(getEnvOrDefault("playlist_job_workers", "4"))
+	if err != nil || workers < 1 {
+		log.Fatalln("playlist_job_workers must be a positive number")
+	}
+	return workers
 }
@@ -0,0 +1,77 @@
+package config
+
+import (
+	"os"
+	"strings"
+)
+
+const (
+	ScopeProfileReadOnlyAnalytics = "read-only-analytics"
+	ScopeProfilePlaylistWriter    = "playlist-writer"
+	ScopeProfileFull              = "full"
+)
+
+var readOnlyAnalyticsScopes = []string{
+	"user-top-read",
+	"user-read-recently-played",
+	"user-library-read",
+	"user-read-private",
+	"user-read-email",
+	"playlist-read-private",
+	"playlist-read-collaborative",
+}
+
+var playlistWriterScopes = append(append([]string{}, readOnlyAnalyticsScopes...),
+	"playlist-modify-private",
+	"playlist-modify-public",
+)
+
+var fullScopes = append(append([]string{}, playlistWriterScopes...),
+	"user-library-modify",
+)
+
+// scopeProfiles maps the named permission sets a client can ask for to spotify scopes
+var scopeProfiles = map[string][]string{
+	ScopeProfileReadOnlyAnalytics: readOnlyAnalyticsScopes,
+	ScopeProfilePlaylistWriter:    playlistWriterScopes,
+	ScopeProfileFull:              fullScopes,
+}
+
+// ScopeProfile returns the spotify scopes of the named profile
+func ScopeProfile(name string) ([]string, bool) {
+	scopes, ok := scopeProfiles[name]
+	return scopes, ok
+}
+
+// ScopeProfileNames returns the names of every configured scope profile
+func ScopeProfileNames() []string {
+	return []string{ScopeProfileReadOnlyAnalytics, ScopeProfilePlaylistWriter, ScopeProfileFull}
+}
+
+func EnvDefaultScopeProfile() string {
+	loadEnv()
+	return getEnvOrDefault("spotify_default_scope_profile", ScopeProfileFull)
+}
+
+func EnvSpotifyRedirectURI() string {
+	loadEnv()
+	return getEnvOrDefault("spotify_redirect_uri", "http://localhost:5000/api/v1/auth/auth_code_callback")
+}
+
+func EnvSpotifyAuthBaseURL() string {
+	loadEnv()
+	return strings.TrimSuffix(getEnvOrDefault("spotify_auth_base_url", "https://accounts.spotify.com"), "/")
+}
+
+func EnvSpotifyAPIBaseURL() string {
+	loadEnv()
+	return strings.TrimSuffix(getEnvOrDefault("spotify_api_base_url", "https://api.spotify.com/v1"), "/")
+}
+
+func getEnvOrDefault(key string, defaultValue string) string {
+	value := os.Getenv(key)
+	if len(value) == 0 {
+		return defaultValue
+	}
+	return value
+}
@@ -0,0 +1,86 @@
+package models
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/bsontype"
+	"go.mongodb.org/mongo-driver/x/bsonx/bsoncore"
+)
+
+// AttributeRange constrains one tunable attribute of a recommendation. Any of the bounds
+// may be left unset, only the ones that are set are sent to spotify
+type AttributeRange struct {
+	Min    *float32 `json:"min,omitempty" bson:"min,omitempty"`
+	Max    *float32 `json:"max,omitempty" bson:"max,omitempty"`
+	Target *float32 `json:"target,omitempty" bson:"target,omitempty"`
+}
+
+// IsSet reports whether the range constrains the attribute at all
+func (r AttributeRange) IsSet() bool {
+	return r.Min != nil || r.Max != nil || r.Target != nil
+}
+
+// attributeRange has the fields of AttributeRange without its decoding methods
+type attributeRange AttributeRange
+
+// legacyTarget converts a value saved before profiles carried ranges, when each attribute was
+// a single target. Zero was what unset attributes were saved as, so it stays unset
+func legacyTarget(value float64) AttributeRange {
+	if value == 0 {
+		return AttributeRange{}
+	}
+	target := float32(value)
+	return AttributeRange{Target: &target}
+}
+
+func (r *AttributeRange) UnmarshalJSON(data []byte) error {
+	data = bytes.TrimSpace(data)
+	if bytes.Equal(data, []byte("null")) {
+		*r = AttributeRange{}
+		return nil
+	}
+	if len(data) > 0 && data[0] == '{' {
+		return json.Unmarshal(data, (*attributeRange)(r))
+	}
+	var value float64
+	if err := json.Unmarshal(data, &value); err != nil {
+		return err
+	}
+	*r = legacyTarget(value)
+	return nil
+}
+
+func (r *AttributeRange) UnmarshalBSONValue(t bsontype.Type, data []byte) error {
+	switch t {
+	case bsontype.Null, bsontype.Undefined:
+		*r = AttributeRange{}
+		return nil
+	case bsontype.EmbeddedDocument:
+		return bson.Unmarshal(data, (*attributeRange)(r))
+	case bsontype.Double:
+		value, _, ok := bsoncore.ReadDouble(data)
+		if !ok {
+			return fmt.Errorf("invalid double for attribute range")
+		}
+		*r = legacyTarget(value)
+		return nil
+	case bsontype.Int32:
+		value, _, ok := bsoncore.ReadInt32(data)
+		if !ok {
+			return fmt.Errorf("invalid int32 for attribute range")
+		}
+		*r = legacyTarget(float64(value))
+		return nil
+	case bsontype.Int64:
+		value, _, ok := bsoncore.ReadInt64(data)
+		if !ok {
+			return fmt.Errorf("invalid int64 for attribute range")
+		}
+		*r = legacyTarget(float64(value))
+		return nil
+	}
+	return fmt.Errorf("cannot decode %s into an attribute range", t)
+}
@@ -0,0 +1,11 @@
+package models
+
+import "time"
+
+// AuthState is what we remember about an authorization request between
+// redirecting the user to spotify and spotify calling us back
+type AuthState struct {
+	CodeVerifier string    `json:"code_verifier,omitempty"`
+	ScopeProfile string    `json:"scope_profile"`
+	CreatedAt    time.Time `json:"created_at"`
+}
@@ -23,6 +23,14 @@ type Tracks struct {
 }
 
 type PlaylistTrack struct {
-	AddedAt string `json:"addedd_at"`
+	AddedAt string `json:"added_at"`
 	Track   Track  `json:"track"`
 }
+
+// PlaylistDetails are the settings a playlist is created with
+type PlaylistDetails struct {
+	Name          string `json:"name"`
+	Description   string `json:"description"`
+	Public        bool   `json:"public"`
+	Collaborative bool   `json:"collaborative"`
+}
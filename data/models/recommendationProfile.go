@@ -1,22 +1,56 @@
 package models
 
-import "go.mongodb.org/mongo-driver/bson/primitive"
+import (
+	"time"
 
+	"go.mongodb.org/mongo-driver/bson/primitive"
+)
+
+// RecommendationProfile is what a playlist's recommendations were requested with
 type RecommendationProfile struct {
 	Id               primitive.ObjectID `json:"id" bson:"_id"`
 	CreatorId        string             `json:"creator_id"`
+	PlaylistId       string             `json:"playlist_id"`
 	PlaylistName     string             `json:"playlist_name"`
 	Limit            int16              `json:"limit"`
 	SeedArtists      []string           `json:"seed_artists"`
 	SeedGenres       []string           `json:"seed_genres"`
 	SeedTracks       []string           `json:"seed_tracks"`
-	Acousticness     float32            `json:"acousticness"`
-	Danceability     float32            `json:"danceability"`
-	Energy           float32            `json:"energy"`
-	Instrumentalness float32            `json:"instrumentalness"`
-	Liveness         float32            `json:"liveness"`
-	Popularity       int16              `json:"popularity"`
-	Valence          float32            `json:"valence"`
-	Tempo            float32            `json:"tempo"`
+	SeedSource       string             `json:"seed_source"`
+	Strategy         string             `json:"strategy"`
+	Acousticness     AttributeRange     `json:"acousticness"`
+	Danceability     AttributeRange     `json:"danceability"`
+	Energy           AttributeRange     `json:"energy"`
+	Instrumentalness AttributeRange     `json:"instrumentalness"`
+	Key              AttributeRange     `json:"key"`
+	Liveness         AttributeRange     `json:"liveness"`
+	Loudness         AttributeRange     `json:"loudness"`
+	Mode             AttributeRange     `json:"mode"`
+	Popularity       AttributeRange     `json:"popularity"`
+	Speechiness      AttributeRange     `json:"speechiness"`
+	Tempo            AttributeRange     `json:"tempo"`
+	TimeSignature    AttributeRange     `json:"time_signature"`
+	Valence          AttributeRange     `json:"valence"`
 	SnapshotId       string             `json:"snapshot_id"`
+	CreatedAt        time.Time          `json:"created_at"`
+	UpdatedAt        time.Time          `json:"updated_at"`
+}
+
+// Attributes maps the name spotify uses for each tunable attribute to its range
+func (p *RecommendationProfile) Attributes() map[string]*AttributeRange {
+	return map[string]*AttributeRange{
+		"acousticness":     &p.Acousticness,
+		"danceability":     &p.Danceability,
+		"energy":           &p.Energy,
+		"instrumentalness": &p.Instrumentalness,
+		"key":              &p.Key,
+		"liveness":         &p.Liveness,
+		"loudness":         &p.Loudness,
+		"mode":             &p.Mode,
+		"popularity":       &p.Popularity,
+		"speechiness":      &p.Speechiness,
+		"tempo":            &p.Tempo,
+		"time_signature":   &p.TimeSignature,
+		"valence":          &p.Valence,
+	}
 }
@@ -9,4 +9,23 @@ type Session struct {
 	RefreshToken string    `json:"refresh_token"`
 	ExpiresIn    int16     `json:"expires_in,omitempty"`
 	IssuedAt     time.Time `json:"issued_at"`
+	// set when the tokens above are encrypted, identifies the key that wrapped DataKey
+	KeyId string `json:"key_id,omitempty"`
+	// the per session data key the tokens are encrypted with, itself encrypted with KeyId
+	DataKey string `json:"data_key,omitempty"`
+}
+
+// IsEncrypted reports whether the session's tokens are stored encrypted
+func (s Session) IsEncrypted() bool {
+	return len(s.KeyId) != 0
+}
+
+// ExpiresAt returns the time at which the session's access token stops being valid
+func (s Session) ExpiresAt() time.Time {
+	return s.IssuedAt.Add(time.Duration(s.ExpiresIn) * time.Second)
+}
+
+// ExpiresWithin reports whether the access token expires within the given window
+func (s Session) ExpiresWithin(window time.Duration) bool {
+	return time.Now().Add(window).After(s.ExpiresAt())
 }
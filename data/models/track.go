@@ -10,6 +10,18 @@ type Track struct {
 	Artists    []Artist `json:"artists,omitempty"`
 }
 
+// SavedTrack is a track in the user's library
+type SavedTrack struct {
+	AddedAt string `json:"added_at"`
+	Track   Track  `json:"track"`
+}
+
+// PlayHistory is a track the user recently played
+type PlayHistory struct {
+	PlayedAt string `json:"played_at"`
+	Track    Track  `json:"track"`
+}
+
 type Artist struct {
 	Id         string   `json:"id,omitempty"`
 	Name       string   `json:"name,omitempty"`
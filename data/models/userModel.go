@@ -7,4 +7,7 @@ type User struct {
 	Country     string  `json:"country"`
 	SpotifyPlan string  `json:"spotify_plan"`
 	Auth        Session `json:"auth"`
+	// the scope profile the user authorized with and the scopes spotify actually granted
+	ScopeProfile  string   `json:"scope_profile"`
+	GrantedScopes []string `json:"granted_scopes"`
 }
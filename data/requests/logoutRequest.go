@@ -0,0 +1,5 @@
+package requests
+
+type LogoutRequest struct {
+	RefreshToken string `json:"refresh_token"`
+}
@@ -0,0 +1,40 @@
+package requests
+
+// PlaylistGenerationRequest describes the playlist a user wants generated.
+// Every field is optional, anything left out falls back to the defaults the
+// legacy create_playlist endpoint uses
+type PlaylistGenerationRequest struct {
+	Name          string                 `json:"name" binding:"omitempty,max=100"`
+	Description   string                 `json:"description" binding:"omitempty,max=300"`
+	Visibility    string                 `json:"visibility" binding:"omitempty,oneof=public private"`
+	Collaborative bool                   `json:"collaborative"`
+	TrackCount    int                    `json:"track_count" binding:"omitempty,min=1,max=100"`
+	SeedTracks    []string               `json:"seed_tracks" binding:"omitempty,max=5,dive,required"`
+	SeedArtists   []string               `json:"seed_artists" binding:"omitempty,max=5,dive,required"`
+	SeedGenres    []string               `json:"seed_genres" binding:"omitempty,max=5,dive,required"`
+	Targets       *FeatureTargets        `json:"targets"`
+	Blend         string                 `json:"blend"`
+	Strategy      string                 `json:"strategy"`
+	MixCount      int                    `json:"mix_count" binding:"omitempty,min=2,max=5"`
+	Filters       *RecommendationFilters `json:"filters"`
+}
+
+// FeatureTargets override the audio feature targets otherwise derived from the user's top tracks
+type FeatureTargets struct {
+	Acousticness     *float32 `json:"acousticness" binding:"omitempty,min=0,max=1"`
+	Danceability     *float32 `json:"danceability" binding:"omitempty,min=0,max=1"`
+	Energy           *float32 `json:"energy" binding:"omitempty,min=0,max=1"`
+	Instrumentalness *float32 `json:"instrumentalness" binding:"omitempty,min=0,max=1"`
+	Liveness         *float32 `json:"liveness" binding:"omitempty,min=0,max=1"`
+	Tempo            *float32 `json:"tempo" binding:"omitempty,min=0,max=250"`
+	Valence          *float32 `json:"valence" binding:"omitempty,min=0,max=1"`
+}
+
+// RecommendationFilters narrow down the tracks spotify recommends before they are added
+type RecommendationFilters struct {
+	ExcludeExplicit    bool `json:"exclude_explicit"`
+	MaxTracksPerArtist int  `json:"max_tracks_per_artist" binding:"omitempty,min=1,max=100"`
+	ExcludeTopTracks   bool `json:"exclude_top_tracks"`
+	MinPopularity      *int `json:"min_popularity" binding:"omitempty,min=0,max=100"`
+	MaxPopularity      *int `json:"max_popularity" binding:"omitempty,min=0,max=100"`
+}
@@ -0,0 +1,5 @@
+package requests
+
+type RegenerateProfileRequest struct {
+	Mode string `json:"mode" binding:"omitempty,oneof=refresh new"`
+}
@@ -6,3 +6,7 @@ type PlaylistCreationResponse struct {
 	Name  string      `json:"name,omitempty"`
 	Owner models.User `json:"owner"`
 }
+
+type SnapshotResponse struct {
+	SnapshotId string `json:"snapshot_id"`
+}
@@ -0,0 +1,9 @@
+package responses
+
+// SearchResponse holds one paging object per type searched for,
+// types that were not part of the search are left out
+type SearchResponse struct {
+	Tracks  *TopItemsResponse `json:"tracks,omitempty"`
+	Artists *TopItemsResponse `json:"artists,omitempty"`
+	Albums  *TopItemsResponse `json:"albums,omitempty"`
+}
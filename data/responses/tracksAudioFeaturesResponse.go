@@ -11,7 +11,12 @@ type Features struct {
 	Energy           float32 `json:"energy"`
 	Id               string  `json:"id"`
 	Instrumentalness float32 `json:"instrumentalness"`
+	Key              int     `json:"key"`
 	Liveness         float32 `json:"liveness"`
+	Loudness         float32 `json:"loudness"`
+	Mode             int     `json:"mode"`
+	Speechiness      float32 `json:"speechiness"`
 	Tempo            float32 `json:"tempo"`
+	TimeSignature    int     `json:"time_signature"`
 	Valence          float32 `json:"valence"`
 }
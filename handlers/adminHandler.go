@@ -0,0 +1,17 @@
+package handlers
+
+import (
+	"mofe64/playlistGen/util"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// GetCircuitBreakers reports the state of the circuit breakers in front of spotify
+func GetCircuitBreakers() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		util.GenerateJSONResponse(c, http.StatusOK, "Success", gin.H{
+			"circuit_breakers": spotifyService.CircuitBreakers(),
+		})
+	}
+}
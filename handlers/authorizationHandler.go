@@ -3,35 +3,71 @@ package handlers
 import (
 	"context"
 	"crypto/rand"
+	"crypto/subtle"
 	"encoding/base64"
-	"encoding/json"
+	"io"
 	"mofe64/playlistGen/config"
 	"mofe64/playlistGen/data/models"
+	"mofe64/playlistGen/data/requests"
 	"mofe64/playlistGen/data/responses"
 	"mofe64/playlistGen/service"
 	"mofe64/playlistGen/util"
 	"net/http"
 	"net/url"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
-	"github.com/sethvargo/go-retry"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
-var spotifyBaseAuthUrl = "https://accounts.spotify.com"
-var spotifyRedirectUri = "http://localhost:5000/api/v1/auth/auth_code_callback"
-var spotifyService service.SpotifyService = service.NewSpotifyService(spotifyRedirectUri)
+var spotifyService service.SpotifyService = service.NewSpotifyService(
+	config.EnvSpotifyRedirectURI(),
+	service.WithClientCredentials(config.EnvSpotifyClientId(), config.EnvSpotifyClientSecret()),
+	service.WithAuthBaseURL(config.EnvSpotifyAuthBaseURL()),
+	service.WithWebAPIBaseURL(config.EnvSpotifyAPIBaseURL()),
+)
 var userCollection = config.GetCollection(config.DATABASE, "users")
 var redis = config.RedisClient
+var tokenCipher = service.NewTokenCipher(config.TokenEncryptionKeys())
+var sessionStore = service.NewSessionStore(redis, userCollection, tokenCipher)
+var tokenManager = service.NewTokenManager(spotifyService, sessionStore)
+var authStateStore = service.NewAuthStateStore(redis)
+var jwtService = service.NewJWTService(config.JWTSecret())
+var revocationStore = service.NewTokenRevocationStore(redis)
+var appTokenProvider = service.NewAppTokenProvider(spotifyService, util.GetApplicationContextInstance())
+
+// the oauth state is also kept in a cookie on the browser it was handed to, so the callback
+// can tell that the browser completing the authorization is the one that started it
+const (
+	authStateCookie     = "oauth_state"
+	authStateCookiePath = "/api/v1/auth"
+	// matches how long the saved state lives
+	authStateCookieMaxAge = 10 * 60
+)
+
+// StartTokenKeyRotation re-encrypts stored spotify sessions that are not yet protected by the
+// active key. Rotating keys is a matter of configuring a new active key and restarting,
+// sessions remain readable while the rotation runs since the previous keys are still configured
+func StartTokenKeyRotation(ctx context.Context) {
+	tag := "AUTH_HANDLER_START_TOKEN_KEY_ROTATION"
+	go func() {
+		rotated, err := sessionStore.RotateEncryptionKeys(ctx)
+		if err != nil {
+			util.ErrorLog.Println(tag+": key rotation did not complete", err.Error())
+			return
+		}
+		util.InfoLog.Println(tag+": rotated sessions to key "+tokenCipher.ActiveKeyId()+": ", rotated)
+	}()
+}
 
 func GetAccessToken() gin.HandlerFunc {
 	tag := "AUTH_HANDLER_GET_ACCESS_TOKEN_CLIENT_CRED"
 	return func(c *gin.Context) {
-		_, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
 		defer cancel()
 
-		spotifyResponse, err := spotifyService.GetAccessTokenWithClientCredentials()
+		accessToken, expiresAt, err := appTokenProvider.GetAppAccessToken(ctx)
 
 		if err != nil {
 			if authError, ok := err.(util.ApplicationAuthError); ok {
@@ -40,37 +76,89 @@ func GetAccessToken() gin.HandlerFunc {
 				return
 			} else {
 				util.ErrorLog.Println(tag+": Internal server error ", err.Error())
-				util.GenerateInternalServerErrorResponse(c, "Something went wrong please try again")
+				util.GenerateErrorResponse(c, err)
 				return
 			}
 		}
 		util.GenerateJSONResponse(c, http.StatusOK, "", gin.H{
-			"access_token": spotifyResponse.AccessToken,
-			"token_type":   spotifyResponse.TokenType,
-			"expires_in":   spotifyResponse.ExpiresIn,
+			"access_token": accessToken,
+			"token_type":   "Bearer",
+			"expires_in":   int64(time.Until(expiresAt).Seconds()),
 		})
 	}
 }
 
-func prepareRedirectURI() string {
+// prepareRedirectURI builds the spotify authorization url the client should be redirected to.
+// The generated state is saved so the callback can verify it, and set in an HttpOnly cookie
+// so the callback can check it is completed by the same browser. When pkce is requested a
+// code verifier is saved alongside it and only its challenge is sent to spotify.
+// The scopes requested are those of the given scope profile
+func prepareRedirectURI(c *gin.Context, ctx context.Context, usePKCE bool, scopeProfile string) (string, error) {
+	scopes, ok := config.ScopeProfile(scopeProfile)
+	if !ok {
+		return "", util.ApplicationError{
+			Message: "Unknown scope profile " + scopeProfile,
+		}
+	}
+	state := generateRandomString(16)
+	authState := models.AuthState{
+		ScopeProfile: scopeProfile,
+		CreatedAt:    time.Now(),
+	}
+
 	// Prepare the query parameters.
 	params := url.Values{}
 	params.Add("client_id", config.EnvSpotifyClientId())
 	params.Add("response_type", "code")
-	params.Add("redirect_uri", "http://localhost:5000/api/v1/auth/auth_code_callback")
-	params.Add("state", generateRandomString(16))
-	params.Add("scope", "playlist-read-private playlist-read-collaborative playlist-modify-private playlist-modify-public user-top-read user-read-recently-played user-library-modify user-library-read user-read-private user-read-email")
-	baseUrl := spotifyBaseAuthUrl + "/authorize"
+	params.Add("redirect_uri", config.EnvSpotifyRedirectURI())
+	params.Add("state", state)
+	params.Add("scope", strings.Join(scopes, " "))
+	if usePKCE {
+		codeVerifier, err := service.GenerateCodeVerifier()
+		if err != nil {
+			return "", err
+		}
+		authState.CodeVerifier = codeVerifier
+		params.Add("code_challenge_method", "S256")
+		params.Add("code_challenge", service.CodeChallengeS256(codeVerifier))
+	}
+
+	if err := authStateStore.SaveState(ctx, state, authState); err != nil {
+		return "", err
+	}
+	setAuthStateCookie(c, state, authStateCookieMaxAge)
+
+	baseUrl := config.EnvSpotifyAuthBaseURL() + "/authorize"
 	redirectURL := baseUrl + "?" + params.Encode()
-	return redirectURL
+	return redirectURL, nil
+
+}
 
+// setAuthStateCookie sets the state cookie, a negative maxAge removes it. It is lax rather
+// than strict since spotify redirecting back to the callback is a cross site navigation
+func setAuthStateCookie(c *gin.Context, state string, maxAge int) {
+	c.SetSameSite(http.SameSiteLaxMode)
+	c.SetCookie(authStateCookie, state, maxAge, authStateCookiePath, "", config.EnvProfile() == "production", true)
 }
 
 func PrepareAuthCodeURI() gin.HandlerFunc {
+	tag := "PREPARE_AUTH_CODE_URI"
 	return func(c *gin.Context) {
-		_, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
 		defer cancel()
-		redirectURL := prepareRedirectURI()
+		// public clients such as our mobile app request pkce so they never need the client secret
+		usePKCE := c.Query("pkce") == "true"
+		scopeProfile := c.DefaultQuery("scope_profile", config.EnvDefaultScopeProfile())
+		if _, ok := config.ScopeProfile(scopeProfile); !ok {
+			util.GenerateBadRequestResponse(c, "Unknown scope profile, expected one of "+strings.Join(config.ScopeProfileNames(), ", "))
+			return
+		}
+		redirectURL, err := prepareRedirectURI(c, ctx, usePKCE, scopeProfile)
+		if err != nil {
+			util.ErrorLog.Println(tag+": could not prepare redirect uri", err.Error())
+			util.GenerateInternalServerErrorResponse(c, "Something went wrong please try again")
+			return
+		}
 
 		c.JSON(http.StatusOK, responses.APIResponse{
 			Status:    http.StatusOK,
@@ -87,7 +175,7 @@ func PrepareAuthCodeURI() gin.HandlerFunc {
 func AuthorizationCodeCallBack() gin.HandlerFunc {
 	tag := "AUTHORIZATION_CODE_CALLBACK"
 	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 20*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 20*time.Second)
 		defer cancel()
 		// extract and log our query parameters
 		code := c.Query("code")
@@ -97,6 +185,35 @@ func AuthorizationCodeCallBack() gin.HandlerFunc {
 		util.InfoLog.Println(tag+": state -> ", state)
 		util.ErrorLog.Println(tag+": error -> ", errorMsg)
 
+		/**
+			Verify the state was handed to this browser, otherwise someone could send a victim
+			a callback url carrying their own code and a state they were given, logging the
+			victim into the sender's account
+		**/
+		cookieState, _ := c.Cookie(authStateCookie)
+		setAuthStateCookie(c, "", -1)
+		if len(cookieState) == 0 || subtle.ConstantTimeCompare([]byte(cookieState), []byte(state)) != 1 {
+			util.ErrorLog.Println(tag + ": state does not match the state cookie")
+			util.GenerateBadRequestResponse(c, "Invalid or expired state parameter")
+			return
+		}
+
+		/**
+			Verify the state matches one we handed out and has not been used before.
+			The saved state is removed at this point, so a replayed callback will be rejected
+		**/
+		authState, err := authStateStore.ConsumeState(ctx, state)
+		if err != nil {
+			if authError, ok := err.(util.ApplicationAuthError); ok {
+				util.ErrorLog.Println(tag+": state verification failed", authError.Message)
+				util.GenerateBadRequestResponse(c, authError.Message)
+				return
+			}
+			util.ErrorLog.Println(tag+": Internal server error", err.Error())
+			util.GenerateInternalServerErrorResponse(c, "Something went wrong, please try again")
+			return
+		}
+
 		/**
 			If our callback is triggered with an error query param,
 			this means our spotify authentcation was not successful.
@@ -112,7 +229,7 @@ func AuthorizationCodeCallBack() gin.HandlerFunc {
 			and we recieved an authorization code. We will exchange this auth code
 			for an acceess and refesh token from spotify
 		**/
-		resp, err := spotifyService.GetAccessTokenWithAuthCode(code)
+		resp, err := spotifyService.GetAccessTokenWithAuthCode(ctx, code, authState.CodeVerifier)
 
 		// return status 500 and log error, if access token operation returns an error
 		if err != nil {
@@ -122,7 +239,7 @@ func AuthorizationCodeCallBack() gin.HandlerFunc {
 				return
 			} else {
 				util.ErrorLog.Println(tag+": Internal server error", err.Error())
-				util.GenerateInternalServerErrorResponse(c, "Something went wrong, please try again")
+				util.GenerateErrorResponse(c, err)
 				return
 			}
 		}
@@ -130,45 +247,26 @@ func AuthorizationCodeCallBack() gin.HandlerFunc {
 		/**
 			Retrive the authenticated user's profile from spotify
 		**/
-		userProfile, err := spotifyService.GetUserProfile(resp.AccessToken)
+		userProfile, err := spotifyService.GetUserProfile(ctx, resp.AccessToken)
 
 		if err != nil {
 			if authError, ok := err.(util.ApplicationAuthError); ok {
 				util.ErrorLog.Println(tag+": authentication error ", authError.Message)
 				// ask client to retry authorization
-				redirectURL := prepareRedirectURI()
+				redirectURL, err := prepareRedirectURI(c, ctx, len(authState.CodeVerifier) != 0, authState.ScopeProfile)
+				if err != nil {
+					util.ErrorLog.Println(tag+": could not prepare redirect uri", err.Error())
+					util.GenerateInternalServerErrorResponse(c, "Something went wrong please try again")
+					return
+				}
 				util.GenerateJSONResponse(c, http.StatusTeapot, "Re-authorization required", gin.H{
 					"url": redirectURL,
 				})
 				return
 			}
-			if rateLimitError, ok := err.(util.ApplicationRateLimitError); ok {
-				util.ErrorLog.Println(tag+": Rate limit error ", rateLimitError.Message)
-				// retry and back off strat.
-				backOff := retry.NewFibonacci(3 * time.Second)
-				backOff = retry.WithMaxRetries(3, backOff)
-
-				if err := retry.Do(ctx, backOff, func(_ context.Context) error {
-					userProfile, err = spotifyService.GetUserProfile(resp.AccessToken)
-					if err != nil {
-						if rateLimitError, ok := err.(util.ApplicationRateLimitError); ok {
-							return retry.RetryableError(rateLimitError)
-						} else {
-							util.GenerateInternalServerErrorResponse(c, "Something went wrong please try again")
-							return nil
-						}
-					}
-					return nil
-				}); err != nil {
-					util.GenerateInternalServerErrorResponse(c, "Something went wrong please try again")
-				}
-				return
-			}
-			if applicationError, ok := err.(util.ApplicationError); ok {
-				util.ErrorLog.Println(tag+": Internal server error ", applicationError.Message)
-				util.GenerateInternalServerErrorResponse(c, "Something went wrong please try again")
-				return
-			}
+			util.ErrorLog.Println(tag+": could not retrieve user profile ", err.Error())
+			util.GenerateErrorResponse(c, err)
+			return
 		}
 
 		util.InfoLog.Println(tag+": user profile ", userProfile)
@@ -197,15 +295,13 @@ func AuthorizationCodeCallBack() gin.HandlerFunc {
 		// if user does not exist
 		if retrieveError != nil {
 			util.InfoLog.Println(tag+": retrieve error", retrieveError.Error())
-			// create new user entry in db based off retrived spotify profile and
-			// authentication details
+			// create new user entry in db based off retrived spotify profile
 			newUser := models.User{
 				Id:          userProfile.Id,
 				Username:    userProfile.DisplayName,
 				Country:     userProfile.Country,
 				Email:       userProfile.Email,
 				SpotifyPlan: userProfile.Product,
-				Auth:        spotifyAuth,
 			}
 			_, err := userCollection.InsertOne(ctx, newUser)
 			if err != nil {
@@ -214,50 +310,202 @@ func AuthorizationCodeCallBack() gin.HandlerFunc {
 				return
 			}
 			user = newUser
-		} else {
-			// if user exists
-			// update user's auth details to the newly generated auth details
-			user.Auth = spotifyAuth
-			filter := bson.M{"id": user.Id}
-			update := bson.M{"$set": user}
-			_, err := userCollection.UpdateOne(ctx, filter, update)
-			if err != nil {
-				util.ErrorLog.Println(tag+": DB Update err", err.Error())
-				util.GenerateInternalServerErrorResponse(c, "Something went wrong, please try again")
-				return
+		}
+
+		/**
+			Record the scopes spotify actually granted, the user may have been asked for
+			a smaller profile than before, or spotify may grant fewer scopes than requested
+		**/
+		grantedScopes := strings.Fields(resp.Scope)
+		granted := map[string]bool{}
+		for _, scope := range grantedScopes {
+			granted[scope] = true
+		}
+		requestedScopes, _ := config.ScopeProfile(authState.ScopeProfile)
+		for _, scope := range requestedScopes {
+			if !granted[scope] {
+				util.InfoLog.Println(tag+": requested scope not granted ", scope)
 			}
+		}
+		user.ScopeProfile = authState.ScopeProfile
+		user.GrantedScopes = grantedScopes
+		scopeUpdate := bson.M{"$set": bson.M{"scopeprofile": user.ScopeProfile, "grantedscopes": user.GrantedScopes}}
+		if _, err := userCollection.UpdateOne(ctx, bson.M{"id": user.Id}, scopeUpdate); err != nil {
+			util.ErrorLog.Println(tag+": DB Update err", err.Error())
+			util.GenerateInternalServerErrorResponse(c, "Something went wrong, please try again")
+			return
+		}
 
+		/**
+			Persist the newly obtained access and refresh tokens as the user's session.
+			The session store writes them to the user's record and caches them in redis
+			mapped to the user's id, so we can efficiently retrieve and refresh them later
+		**/
+		if err := sessionStore.SaveSession(ctx, user.Id, spotifyAuth); err != nil {
+			util.ErrorLog.Println(tag+": Could not save session", err.Error())
+			util.GenerateInternalServerErrorResponse(c, "Something went wrong, please try again")
+			return
 		}
+
 		/**
-			To avoid having to go to the db everytime to retrieve user's auth details,
-			we are going to store the auth details in redis, mapped to the user's id
-			This way we can more efficiently retrieve the auth details and use it.
-			To do this, we first convert our session struct (spotifyAuth) to json,
-			we will then stringify the json value and store it as a string in redis.
-			The auth details will be mapped to the user id for easy retrieval
+			Issue our own signed tokens for the user. The spotify tokens never leave the server,
+			clients authenticate subsequent requests with the access token issued here
 		**/
-		jsonValue, err := json.Marshal(spotifyAuth)
-		// if there was an error converting auth details to json format return 500 error res
+		tokenPair, err := jwtService.GenerateTokenPair(user.Id)
 		if err != nil {
-			util.ErrorLog.Println(tag+": Could not convert spotify token details to json", err.Error())
+			util.ErrorLog.Println(tag+": Could not generate tokens", err.Error())
 			util.GenerateInternalServerErrorResponse(c, "Something went wrong, please try again")
 			return
 		}
-		// store spotify auth details in redis kv
-		// Todo handle siuations where redis is unavailable
-		util.InfoLog.Println(tag + ": about to set to redis key --> " + user.Id)
-		redis.Set(ctx, user.Id, string(jsonValue), 0)
 
 		// return success response to user
 		util.GenerateJSONResponse(c, http.StatusOK, "Success", gin.H{
-			"access_token": resp.AccessToken,
-			"token_type":   resp.TokenType,
-			"expires_in":   resp.ExpiresIn,
-			"userId":       user.Id,
+			"access_token":   tokenPair.AccessToken,
+			"refresh_token":  tokenPair.RefreshToken,
+			"token_type":     tokenPair.TokenType,
+			"expires_in":     tokenPair.ExpiresIn,
+			"userId":         user.Id,
+			"scope_profile":  user.ScopeProfile,
+			"granted_scopes": user.GrantedScopes,
 		})
 	}
 }
 
+// RefreshToken exchanges a valid refresh token for a new access/refresh token pair
+func RefreshToken() gin.HandlerFunc {
+	tag := "AUTH_HANDLER_REFRESH_TOKEN"
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+		var request requests.RefreshTokenRequest
+		if err := c.ShouldBindJSON(&request); err != nil {
+			util.GenerateBadRequestResponse(c, err.Error())
+			return
+		}
+
+		claims, err := jwtService.ValidateToken(request.RefreshToken, service.RefreshTokenType)
+		if err != nil {
+			util.ErrorLog.Println(tag+": refresh token validation failed", err.Error())
+			generateUnauthorizedResponse(c, err.Error())
+			return
+		}
+
+		revoked, err := revocationStore.IsRevoked(ctx, claims)
+		if err != nil {
+			util.ErrorLog.Println(tag+": could not check token revocation", err.Error())
+			util.GenerateInternalServerErrorResponse(c, "Something went wrong, please try again")
+			return
+		}
+		if revoked {
+			generateUnauthorizedResponse(c, "Token has been revoked")
+			return
+		}
+
+		// refresh tokens are single use, the presented one is replaced by the new pair
+		consumed, err := revocationStore.ConsumeToken(ctx, claims)
+		if err != nil {
+			util.ErrorLog.Println(tag+": could not revoke used refresh token", err.Error())
+			util.GenerateInternalServerErrorResponse(c, "Something went wrong, please try again")
+			return
+		}
+		if !consumed {
+			generateUnauthorizedResponse(c, "Token has been revoked")
+			return
+		}
+
+		tokenPair, err := jwtService.GenerateTokenPair(claims.Subject)
+		if err != nil {
+			util.ErrorLog.Println(tag+": Could not generate tokens", err.Error())
+			util.GenerateInternalServerErrorResponse(c, "Something went wrong, please try again")
+			return
+		}
+
+		util.GenerateJSONResponse(c, http.StatusOK, "Success", gin.H{
+			"access_token":  tokenPair.AccessToken,
+			"refresh_token": tokenPair.RefreshToken,
+			"token_type":    tokenPair.TokenType,
+			"expires_in":    tokenPair.ExpiresIn,
+			"userId":        claims.Subject,
+		})
+	}
+}
+
+// Logout ends the caller's session. The presented access token, and the refresh token
+// when one is sent along, are deny-listed and the user's spotify session is removed
+func Logout() gin.HandlerFunc {
+	tag := "AUTH_HANDLER_LOGOUT"
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+		claims := c.MustGet("claims").(*service.AuthClaims)
+
+		var request requests.LogoutRequest
+		if err := c.ShouldBindJSON(&request); err != nil && err != io.EOF {
+			util.GenerateBadRequestResponse(c, err.Error())
+			return
+		}
+
+		if err := revocationStore.RevokeToken(ctx, claims); err != nil {
+			util.ErrorLog.Println(tag+": could not revoke access token", err.Error())
+			util.GenerateInternalServerErrorResponse(c, "Something went wrong, please try again")
+			return
+		}
+		if len(request.RefreshToken) != 0 {
+			refreshClaims, err := jwtService.ValidateToken(request.RefreshToken, service.RefreshTokenType)
+			// a refresh token belonging to someone else is ignored rather than revoked
+			if err == nil && refreshClaims.Subject == claims.Subject {
+				if err := revocationStore.RevokeToken(ctx, refreshClaims); err != nil {
+					util.ErrorLog.Println(tag+": could not revoke refresh token", err.Error())
+					util.GenerateInternalServerErrorResponse(c, "Something went wrong, please try again")
+					return
+				}
+			}
+		}
+
+		if err := sessionStore.DeleteSession(ctx, claims.Subject); err != nil {
+			util.ErrorLog.Println(tag+": could not delete session", err.Error())
+			util.GenerateInternalServerErrorResponse(c, "Something went wrong, please try again")
+			return
+		}
+
+		util.GenerateJSONResponse(c, http.StatusOK, "Logged out", gin.H{})
+	}
+}
+
+// RevokeAllSessions invalidates every token issued to the caller so far, on every device,
+// and removes the user's spotify session. The user has to authorize again afterwards
+func RevokeAllSessions() gin.HandlerFunc {
+	tag := "AUTH_HANDLER_REVOKE_ALL_SESSIONS"
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+		userId := c.GetString("userId")
+
+		if err := revocationStore.RevokeAllTokens(ctx, userId); err != nil {
+			util.ErrorLog.Println(tag+": could not revoke tokens", err.Error())
+			util.GenerateInternalServerErrorResponse(c, "Something went wrong, please try again")
+			return
+		}
+		if err := sessionStore.DeleteSession(ctx, userId); err != nil {
+			util.ErrorLog.Println(tag+": could not delete session", err.Error())
+			util.GenerateInternalServerErrorResponse(c, "Something went wrong, please try again")
+			return
+		}
+
+		util.GenerateJSONResponse(c, http.StatusOK, "All sessions revoked", gin.H{})
+	}
+}
+
+func generateUnauthorizedResponse(c *gin.Context, message string) {
+	c.JSON(http.StatusUnauthorized, responses.APIResponse{
+		Status:    http.StatusUnauthorized,
+		Message:   message,
+		Timestamp: time.Now(),
+		Data:      gin.H{},
+		Success:   false,
+	})
+}
+
 func generateRandomString(length int) string {
 	b := make([]byte, length)
 	rand.Read(b)
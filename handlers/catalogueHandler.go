@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"mofe64/playlistGen/data/responses"
+	"mofe64/playlistGen/util"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+var searchTypes = map[string]bool{"track": true, "artist": true, "album": true}
+
+// SearchCatalogue searches spotify's catalogue using the app access token,
+// no user has to be logged in
+func SearchCatalogue() gin.HandlerFunc {
+	tag := "CATALOGUE_HANDLER_SEARCH"
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		query := c.Query("q")
+		if len(query) == 0 {
+			util.GenerateBadRequestResponse(c, "q query parameter required")
+			return
+		}
+		types := strings.Split(c.DefaultQuery("type", "track,artist"), ",")
+		for _, searchType := range types {
+			if !searchTypes[searchType] {
+				util.GenerateBadRequestResponse(c, "Unsupported search type "+searchType)
+				return
+			}
+		}
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", "20"))
+		if err != nil || limit < 1 || limit > 50 {
+			util.GenerateBadRequestResponse(c, "limit must be a number between 1 and 50")
+			return
+		}
+		offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if err != nil || offset < 0 {
+			util.GenerateBadRequestResponse(c, "offset must be a positive number")
+			return
+		}
+
+		accessToken, _, err := appTokenProvider.GetAppAccessToken(ctx)
+		if err != nil {
+			util.ErrorLog.Println(tag+": could not get app access token", err.Error())
+			util.GenerateErrorResponse(c, err)
+			return
+		}
+
+		results, err := spotifyService.Search(ctx, accessToken, query, types, limit, offset)
+		if err != nil {
+			util.ErrorLog.Println(tag+": could not search catalogue", err.Error())
+			util.GenerateErrorResponse(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, responses.APIResponse{
+			Status:    http.StatusOK,
+			Message:   "Success",
+			Timestamp: time.Now(),
+			Data: gin.H{
+				"tracks":  results.Tracks,
+				"artists": results.Artists,
+				"albums":  results.Albums,
+			},
+			Success: true,
+		})
+	}
+}
+
+// GetArtist looks up an artist in spotify's catalogue using the app access token
+func GetArtist() gin.HandlerFunc {
+	tag := "CATALOGUE_HANDLER_GET_ARTIST"
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+
+		accessToken, _, err := appTokenProvider.GetAppAccessToken(ctx)
+		if err != nil {
+			util.ErrorLog.Println(tag+": could not get app access token", err.Error())
+			util.GenerateErrorResponse(c, err)
+			return
+		}
+
+		artist, err := spotifyService.GetArtist(ctx, accessToken, c.Param("artistId"))
+		if err != nil {
+			util.ErrorLog.Println(tag+": could not get artist", err.Error())
+			util.GenerateErrorResponse(c, err)
+			return
+		}
+
+		c.JSON(http.StatusOK, responses.APIResponse{
+			Status:    http.StatusOK,
+			Message:   "Success",
+			Timestamp: time.Now(),
+			Data: gin.H{
+				"artist": artist,
+			},
+			Success: true,
+		})
+	}
+}
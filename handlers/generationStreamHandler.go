@@ -0,0 +1,225 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"mofe64/playlistGen/data/responses"
+	"mofe64/playlistGen/service"
+	"mofe64/playlistGen/util"
+	"net/http"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// the client sees progress while it waits, so a streamed generation may take longer than a request
+const streamGenerationTimeout = 60 * time.Second
+
+const (
+	// a job may wait in the queue before it runs for up to its own timeout
+	jobStreamTimeout = 5 * time.Minute
+	// how often a job's event stream checks the job for progress
+	jobStreamPollInterval = 500 * time.Millisecond
+)
+
+var streamTicketStore = service.NewStreamTicketStore(redis)
+
+type generationEvent struct {
+	name string
+	data interface{}
+}
+
+// streamProgressReporter forwards generation progress to the event stream, giving up
+// once the stream's context is done so a client that went away does not block generation
+type streamProgressReporter struct {
+	events chan<- generationEvent
+	done   <-chan struct{}
+}
+
+func (r *streamProgressReporter) send(name string, data interface{}) {
+	select {
+	case r.events <- generationEvent{name: name, data: data}:
+	case <-r.done:
+	}
+}
+
+func (r *streamProgressReporter) StageStarted(stage string) {
+	r.send("stage_started", gin.H{"stage": stage})
+}
+
+func (r *streamProgressReporter) StageCompleted(stage string, payload interface{}) {
+	r.send("stage_completed", gin.H{"stage": stage, "payload": payload})
+}
+
+func (r *streamProgressReporter) StageFailed(stage string, err error) {
+	r.send("stage_failed", gin.H{"stage": stage, "error": err.Error()})
+}
+
+// StreamPlaylistGeneration generates a playlist as described by the request body, streaming
+// server sent events as each stage starts and completes. Completed stages carry what they
+// produced, the final done event carries the whole result and an error event ends a failed run.
+// The request needs a json body and a bearer token, which a browser's EventSource cannot send,
+// so it has to be read with fetch. EventSource clients queue a job and follow StreamJobEvents
+func StreamPlaylistGeneration() gin.HandlerFunc {
+	tag := "STREAM_PLAYLIST_GENERATION_HANDLER"
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), streamGenerationTimeout)
+		defer cancel()
+		userId := c.Param("userId")
+		spec, ok := bindPlaylistSpec(c)
+		if !ok {
+			return
+		}
+		// resolve the session before streaming so auth failures get the usual json response
+		sessionDetails, ok := getSpotifySession(c, ctx, tag, userId)
+		if !ok {
+			return
+		}
+
+		events := make(chan generationEvent, 16)
+		go func() {
+			defer close(events)
+			reporter := &streamProgressReporter{events: events, done: ctx.Done()}
+			result, err := playlistGenerator.Generate(ctx, userId, sessionDetails.AccessToken, spec, reporter)
+			if err != nil {
+				util.ErrorLog.Println(tag+": could not generate playlist", err.Error())
+				data := gin.H{"error": err.Error()}
+				// a playlist created before the failure is reported so the client can finish it
+				if result != nil {
+					data["playlist"] = result.Playlist
+					data["recommendationConfig"] = result.Profile
+				}
+				reporter.send("error", data)
+				return
+			}
+			reporter.send("done", result)
+		}()
+
+		c.Stream(func(w io.Writer) bool {
+			event, ok := <-events
+			if !ok {
+				return false
+			}
+			c.SSEvent(event.name, event.data)
+			return true
+		})
+	}
+}
+
+// CreateJobStreamTicket issues a short lived ticket for reading the events of one of the
+// user's jobs with StreamJobEvents, since EventSource cannot send the bearer token
+func CreateJobStreamTicket() gin.HandlerFunc {
+	tag := "CREATE_JOB_STREAM_TICKET_HANDLER"
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+		userId := c.Param("userId")
+		job, err := jobQueue.GetJob(ctx, userId, c.Param("jobId"))
+		if err != nil {
+			util.GenerateErrorResponse(c, err)
+			return
+		}
+		ticket, err := streamTicketStore.IssueTicket(ctx, userId, job.Id)
+		if err != nil {
+			util.ErrorLog.Println(tag+": could not issue ticket", err.Error())
+			util.GenerateInternalServerErrorResponse(c, "Something went wrong please try again")
+			return
+		}
+		c.JSON(http.StatusCreated, responses.APIResponse{
+			Status:    http.StatusCreated,
+			Message:   "Ticket issued",
+			Timestamp: time.Now(),
+			Data: gin.H{
+				"ticket":    ticket,
+				"eventsUrl": "/api/v1/user/" + userId + "/jobs/" + job.Id + "/events?ticket=" + ticket,
+				"expiresAt": time.Now().Add(service.StreamTicketTTL),
+			},
+			Success: true,
+		})
+	}
+}
+
+// StreamJobEvents streams the progress of a background job as server sent events, using the
+// same events as StreamPlaylistGeneration. It is a plain GET authorized by the ticket query
+// parameter from CreateJobStreamTicket, so a browser's EventSource can read it. A client that
+// reconnects is sent every stage again from the start, followed by the job's current state
+func StreamJobEvents() gin.HandlerFunc {
+	tag := "STREAM_JOB_EVENTS_HANDLER"
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), jobStreamTimeout)
+		defer cancel()
+		userId := c.Param("userId")
+		jobId := c.Param("jobId")
+		if err := streamTicketStore.VerifyTicket(ctx, c.Query("ticket"), userId, jobId); err != nil {
+			util.GenerateErrorResponse(c, err)
+			return
+		}
+		job, err := jobQueue.GetJob(ctx, userId, jobId)
+		if err != nil {
+			util.GenerateErrorResponse(c, err)
+			return
+		}
+
+		events := make(chan generationEvent, 16)
+		go func() {
+			defer close(events)
+			reporter := &streamProgressReporter{events: events, done: ctx.Done()}
+			reported := map[string]string{}
+			ticker := time.NewTicker(jobStreamPollInterval)
+			defer ticker.Stop()
+			for {
+				reportJobStages(reporter, job, reported)
+				switch job.Status {
+				case service.JobStatusSucceeded:
+					reporter.send("done", job)
+					return
+				case service.JobStatusFailed:
+					reporter.send("error", gin.H{"error": job.Error, "job": job})
+					return
+				}
+				select {
+				case <-ctx.Done():
+					return
+				case <-ticker.C:
+				}
+				if job, err = jobQueue.GetJob(ctx, userId, jobId); err != nil {
+					util.ErrorLog.Println(tag+": could not load job", err.Error())
+					reporter.send("error", gin.H{"error": "Could not load job, please try again"})
+					return
+				}
+			}
+		}()
+
+		c.Stream(func(w io.Writer) bool {
+			event, ok := <-events
+			if !ok {
+				return false
+			}
+			c.SSEvent(event.name, event.data)
+			return true
+		})
+	}
+}
+
+// reportJobStages sends an event for each stage of the job whose status changed since it
+// was last reported, recording the reported statuses by stage name
+func reportJobStages(reporter *streamProgressReporter, job *service.PlaylistJob, reported map[string]string) {
+	for _, stage := range job.Stages {
+		if reported[stage.Name] == stage.Status {
+			continue
+		}
+		previous := reported[stage.Name]
+		reported[stage.Name] = stage.Status
+		switch stage.Status {
+		case service.StageStatusRunning:
+			reporter.send("stage_started", gin.H{"stage": stage.Name})
+		case service.StageStatusCompleted:
+			if previous == "" || previous == service.StageStatusPending {
+				reporter.send("stage_started", gin.H{"stage": stage.Name})
+			}
+			reporter.send("stage_completed", gin.H{"stage": stage.Name})
+		case service.StageStatusFailed:
+			reporter.send("stage_failed", gin.H{"stage": stage.Name, "error": stage.Error})
+		}
+	}
+}
@@ -0,0 +1,107 @@
+package handlers
+
+import (
+	"context"
+	"io"
+	"mofe64/playlistGen/data/requests"
+	"mofe64/playlistGen/data/responses"
+	"mofe64/playlistGen/util"
+	"net/http"
+	"strconv"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+const (
+	defaultProfilesPageSize = 20
+	maxProfilesPageSize     = 50
+)
+
+// ListProfiles returns a page of the recommendation profiles generated for the user, newest first
+func ListProfiles() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+		limit, err := strconv.Atoi(c.DefaultQuery("limit", strconv.Itoa(defaultProfilesPageSize)))
+		if err != nil || limit < 1 || limit > maxProfilesPageSize {
+			util.GenerateBadRequestResponse(c, "limit must be a number between 1 and "+strconv.Itoa(maxProfilesPageSize))
+			return
+		}
+		offset, err := strconv.Atoi(c.DefaultQuery("offset", "0"))
+		if err != nil || offset < 0 {
+			util.GenerateBadRequestResponse(c, "offset must be a positive number")
+			return
+		}
+		profiles, total, err := profileStore.ListProfiles(ctx, c.Param("userId"), int64(limit), int64(offset))
+		if err != nil {
+			util.GenerateErrorResponse(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, responses.APIResponse{
+			Status:    http.StatusOK,
+			Message:   "Success",
+			Timestamp: time.Now(),
+			Data: gin.H{
+				"profiles": profiles,
+				"total":    total,
+				"limit":    limit,
+				"offset":   offset,
+			},
+			Success: true,
+		})
+	}
+}
+
+func GetProfile() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+		profile, err := profileStore.GetProfile(ctx, c.Param("userId"), c.Param("profileId"))
+		if err != nil {
+			util.GenerateErrorResponse(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, responses.APIResponse{
+			Status:    http.StatusOK,
+			Message:   "Success",
+			Timestamp: time.Now(),
+			Data:      gin.H{"profile": profile},
+			Success:   true,
+		})
+	}
+}
+
+// RegenerateProfile gets fresh recommendations for a saved profile and either refreshes
+// the playlist it created or makes a new one, depending on the requested mode
+func RegenerateProfile() gin.HandlerFunc {
+	tag := "REGENERATE_PROFILE_HANDLER"
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+		userId := c.Param("userId")
+		var request requests.RegenerateProfileRequest
+		if err := c.ShouldBindJSON(&request); err != nil && err != io.EOF {
+			util.GenerateBadRequestResponse(c, err.Error())
+			return
+		}
+		profile, err := profileStore.GetProfile(ctx, userId, c.Param("profileId"))
+		if err != nil {
+			util.GenerateErrorResponse(c, err)
+			return
+		}
+		sessionDetails, ok := getSpotifySession(c, ctx, tag, userId)
+		if !ok {
+			return
+		}
+		result, err := playlistGenerator.Regenerate(ctx, userId, sessionDetails.AccessToken, *profile, request.Mode, nil)
+		if err != nil {
+			if applicationError, ok := err.(util.ApplicationError); ok && applicationError.Status == http.StatusBadRequest {
+				util.GenerateBadRequestResponse(c, applicationError.Message)
+				return
+			}
+			util.ErrorLog.Println(tag+": could not regenerate playlist", err.Error())
+		}
+		publishedPlaylistResponse(c, result, err)
+	}
+}
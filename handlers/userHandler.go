@@ -2,238 +2,92 @@ package handlers
 
 import (
 	"context"
-	"encoding/json"
+	"errors"
+	"io"
 	"mofe64/playlistGen/config"
 	"mofe64/playlistGen/data/models"
+	"mofe64/playlistGen/data/requests"
 	"mofe64/playlistGen/data/responses"
+	"mofe64/playlistGen/service"
 	"mofe64/playlistGen/util"
 	"net/http"
-	"sync"
+	"strconv"
 	"time"
 
 	"github.com/gin-gonic/gin"
 	"go.mongodb.org/mongo-driver/bson"
 )
 
-const CUTOFF = 50
-
 var recommendationProfileCollection = config.GetCollection(config.DATABASE, "recommendationProfiles")
 
+var profileStore = service.NewProfileStore(recommendationProfileCollection)
+var playlistGenerator = service.NewPlaylistGenerator(spotifyService, profileStore)
+var previewStore = service.NewPreviewStore(redis)
+var jobQueue = service.NewJobQueue(redis, playlistGenerator, tokenManager)
+
+// StartPlaylistJobWorkers starts processing background playlist jobs until ctx is cancelled
+func StartPlaylistJobWorkers(ctx context.Context) {
+	jobQueue.Start(ctx, config.EnvPlaylistJobWorkers())
+}
+
+// CreatePlaylist generates a playlist with the default settings. It is kept for clients
+// built before GeneratePlaylist accepted a request body
 func CreatePlaylist() gin.HandlerFunc {
 	tag := "CREATE_PLAYLIST_HANDLER"
 	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
 		defer cancel()
 		userId := c.Param("userId")
-		value := c.GetString("userDetails")
-		util.InfoLog.Println(tag+" : userdetails on req ctx", value)
-		var sessionDetails models.Session
-		/**
-			if session details not found on context
-			(should be added to context by require auth middleware after
-			retrieving from redis)
-		**/
-		if len(value) == 0 {
-			// initialize session details to saved user auth
-			var user models.User
-			err := userCollection.FindOne(ctx, bson.M{"id": userId}).Decode(&user)
-			if err != nil {
-				c.JSON(
-					http.StatusBadRequest,
-					responses.APIResponse{
-						Status:    http.StatusNotFound,
-						Message:   "Not found",
-						Timestamp: time.Now(),
-						Data:      gin.H{"error": "No user found with Id " + userId},
-						Success:   false,
-					},
-				)
-				return
-			}
-			sessionDetails = user.Auth
-		} else {
-			err := json.Unmarshal([]byte(value), &sessionDetails)
-			if err != nil {
-				util.ErrorLog.Println(tag+": could not parse session details", err.Error())
-				c.JSON(
-					http.StatusInternalServerError,
-					responses.APIResponse{
-						Status:    http.StatusInternalServerError,
-						Message:   "Something went wrong",
-						Timestamp: time.Now(),
-						Data:      gin.H{"error": "Internal Error, please try again"},
-						Success:   false,
-					},
-				)
-				return
-			}
-		}
-		util.InfoLog.Println(tag+": parsed session details ", sessionDetails)
-
-		var operationErrorMutex sync.Mutex
-		var operationError error
-		var wg sync.WaitGroup
-		ch := make(chan responses.TopItemsResponse, 2)
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			data, err := spotifyService.GetUserTopItems(sessionDetails.AccessToken, "tracks")
-			if err != nil {
-				util.ErrorLog.Println(tag+": could not retrieve user top tracks", err.Error())
-				operationErrorMutex.Lock()
-				operationError = err
-				operationErrorMutex.Unlock()
-				return
-			}
-			ch <- *data
-		}()
-		wg.Add(1)
-		go func() {
-			defer wg.Done()
-			data, err := spotifyService.GetUserTopItems(sessionDetails.AccessToken, "artists")
-			if err != nil {
-				util.ErrorLog.Println(tag+": could not retrieve user top artists", err.Error())
-				operationErrorMutex.Lock()
-				operationError = err
-				operationErrorMutex.Unlock()
-				return
-			}
-			ch <- *data
-		}()
-
-		if operationError != nil {
-			c.JSON(
-				http.StatusInternalServerError,
-				responses.APIResponse{
-					Status:    http.StatusInternalServerError,
-					Message:   "Something went wrong",
-					Timestamp: time.Now(),
-					Data:      gin.H{"error": "Internal Error, please try again"},
-					Success:   false,
-				},
-			)
-			return
-		}
-
-		wg.Wait()
-		close(ch)
-		var userTopTracks responses.TopItemsResponse
-		var userTopArtists responses.TopItemsResponse
-		for data := range ch {
-			if data.Items[0].Type == "artist" {
-				userTopArtists = data
-			} else {
-				userTopTracks = data
-			}
-		}
-
-		var tracksToAnalyze []string = []string{}
-		for index, track := range userTopTracks.Items {
-			if index < CUTOFF-1 {
-				tracksToAnalyze = append(tracksToAnalyze, track.Id)
-			} else {
-				break
-			}
-		}
-
-		features, err := spotifyService.GetTracksAudioFeatures(tracksToAnalyze, sessionDetails.AccessToken)
+		blend, err := parseTasteBlend(c)
 		if err != nil {
-			util.ErrorLog.Println(tag+": could not get audio features for tracks", err.Error())
-			c.JSON(
-				http.StatusInternalServerError,
-				responses.APIResponse{
-					Status:    http.StatusInternalServerError,
-					Message:   "Something went wrong",
-					Timestamp: time.Now(),
-					Data:      gin.H{"error": "Internal Error, please try again"},
-					Success:   false,
-				},
-			)
+			util.GenerateBadRequestResponse(c, err.Error())
 			return
 		}
-		recommendationConfig := calculateRecommendationConfig(*features)
-		recommendationConfig.Limit = 25
-		recommendationConfig.CreatorId = userId
-
-		var seedTracks = userTopTracks.Items[:3]
-		var seedArtists = userTopArtists.Items[:2]
+		generatePlaylist(c, ctx, tag, userId, service.DefaultPlaylistSpec(blend))
+	}
+}
 
-		var trackSeedIds []string = []string{}
-		var artistSeedIds []string = []string{}
-		for _, track := range seedTracks {
-			trackSeedIds = append(trackSeedIds, track.Id)
-		}
-		for _, artist := range seedArtists {
-			artistSeedIds = append(artistSeedIds, artist.Id)
+// GeneratePlaylist generates a playlist as described by the request body
+func GeneratePlaylist() gin.HandlerFunc {
+	tag := "GENERATE_PLAYLIST_HANDLER"
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+		userId := c.Param("userId")
+		spec, ok := bindPlaylistSpec(c)
+		if !ok {
+			return
 		}
+		generatePlaylist(c, ctx, tag, userId, spec)
+	}
+}
 
-		recommendationConfig.SeedTracks = trackSeedIds
-		recommendationConfig.SeedArtists = artistSeedIds
-
-		recomms, err := spotifyService.GetRecommendations(sessionDetails.AccessToken, *recommendationConfig)
-		if err != nil {
-			util.ErrorLog.Println(tag+": could not get recommendations", err.Error())
-			c.JSON(
-				http.StatusInternalServerError,
-				responses.APIResponse{
-					Status:    http.StatusInternalServerError,
-					Message:   "Something went wrong",
-					Timestamp: time.Now(),
-					Data:      gin.H{"error": "Internal Error, please try again"},
-					Success:   false,
-				},
-			)
+// PreviewPlaylist works out the tracks a generation request would produce without creating
+// anything on the user's spotify account. The returned preview token can be passed to
+// CommitPlaylistPreview to create exactly that playlist
+func PreviewPlaylist() gin.HandlerFunc {
+	tag := "PREVIEW_PLAYLIST_HANDLER"
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+		userId := c.Param("userId")
+		spec, ok := bindPlaylistSpec(c)
+		if !ok {
 			return
 		}
-
-		createdPlaylist, err := spotifyService.CreatePlaylist(
-			sessionDetails.AccessToken,
-			userId,
-			"Nubari radio for you",
-			"A custom playlist built just for you",
-		)
-		uris := []string{}
-
-		for _, track := range recomms.Tracks {
-			uris = append(uris, track.Uri)
+		sessionDetails, ok := getSpotifySession(c, ctx, tag, userId)
+		if !ok {
+			return
 		}
-		snapshotId, err := spotifyService.AddTracksToPlaylist(
-			sessionDetails.AccessToken,
-			createdPlaylist.Id,
-			uris,
-		)
-
-		recommendationConfig.SnapshotId = snapshotId
-		recommendationConfig.PlaylistName = "Nubari radio for you"
-
+		draft, err := playlistGenerator.Draft(ctx, userId, sessionDetails.AccessToken, spec, nil)
 		if err != nil {
-			util.ErrorLog.Println(tag+": could not create playlist", err.Error())
-			c.JSON(
-				http.StatusInternalServerError,
-				responses.APIResponse{
-					Status:    http.StatusInternalServerError,
-					Message:   "Something went wrong",
-					Timestamp: time.Now(),
-					Data:      gin.H{"error": "Internal Error, please try again"},
-					Success:   false,
-				},
-			)
+			util.ErrorLog.Println(tag+": could not draft playlist", err.Error())
+			util.GenerateErrorResponse(c, err)
 			return
 		}
-
-		_, insertErr := recommendationProfileCollection.InsertOne(ctx, recommendationConfig)
-		if insertErr != nil {
-			util.ErrorLog.Println(tag+": DB Insertion err", err.Error())
-			c.JSON(
-				http.StatusInternalServerError,
-				responses.APIResponse{
-					Status:    http.StatusInternalServerError,
-					Message:   "Something went wrong",
-					Timestamp: time.Now(),
-					Data:      gin.H{"error": "Internal Error, please try again"},
-					Success:   false,
-				},
-			)
+		previewToken := generateRandomString(32)
+		if err := previewStore.SavePreview(ctx, userId, previewToken, *draft); err != nil {
+			util.GenerateInternalServerErrorResponse(c, "Something went wrong please try again")
 			return
 		}
 
@@ -242,140 +96,287 @@ func CreatePlaylist() gin.HandlerFunc {
 			Message:   "Success",
 			Timestamp: time.Now(),
 			Data: gin.H{
-				"playlist":             createdPlaylist,
-				"recommendations":      recomms,
-				"topTracks":            userTopTracks,
-				"topArtists":           userTopArtists,
-				"features":             features,
-				"recommendationConfig": recommendationConfig,
-				"snapshotId":           snapshotId,
+				"previewToken":         previewToken,
+				"expiresAt":            time.Now().Add(service.PreviewTTL),
+				"playlist":             draft.Details,
+				"recommendations":      draft.Recommendations,
+				"topTracks":            draft.TopTracks,
+				"topArtists":           draft.TopArtists,
+				"features":             draft.Features,
+				"recommendationConfig": draft.Profile,
+				"seedSource":           draft.SeedSource,
+				"refinement":           draft.Refinement,
 			},
 			Success: true,
 		})
 	}
 }
 
-func calculateRecommendationConfig(trackFeatures responses.TracksAudioFeatures) *models.RecommendationProfile {
-	var accousticnessLow []float32
-	var accousticnessHigh []float32
-	var danceabilityLow []float32
-	var danceabilityHigh []float32
-	var energyLow []float32
-	var energyHigh []float32
-	var instrumentalnessLow []float32
-	var instrumentalnessHigh []float32
-	var livenessHigh []float32
-	var livenessLow []float32
-	var tempo []float32
-	var valenceLow []float32
-	var valenceHigh []float32
-
-	var desiredAcousticness float32
-	var desiredDanceability float32
-	var desiredEnergy float32
-	var desiredInstrumentalness float32
-	var desiredLiveness float32
-	var desiredTempo float32
-	var desiredValence float32
-
-	for _, feature := range trackFeatures.AudioFeatures {
-		if feature.Acousticness >= 0.5 {
-			accousticnessHigh = append(accousticnessHigh, feature.Acousticness)
-		} else {
-			accousticnessLow = append(accousticnessLow, feature.Acousticness)
+// CommitPlaylistPreview creates the playlist a preview proposed, with the same tracks
+func CommitPlaylistPreview() gin.HandlerFunc {
+	tag := "COMMIT_PLAYLIST_PREVIEW_HANDLER"
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+		userId := c.Param("userId")
+		previewToken := c.Param("previewToken")
+		sessionDetails, ok := getSpotifySession(c, ctx, tag, userId)
+		if !ok {
+			return
 		}
-
-		if feature.Danceability >= 0.5 {
-			danceabilityHigh = append(danceabilityHigh, feature.Danceability)
-		} else {
-			danceabilityLow = append(danceabilityLow, feature.Danceability)
+		draft, err := previewStore.ConsumePreview(ctx, userId, previewToken)
+		if err != nil {
+			util.GenerateErrorResponse(c, err)
+			return
 		}
-
-		if feature.Energy >= 0.5 {
-			energyHigh = append(energyHigh, feature.Energy)
-		} else {
-			energyLow = append(energyLow, feature.Energy)
+		result, err := playlistGenerator.Publish(ctx, userId, sessionDetails.AccessToken, *draft, nil)
+		if err != nil {
+			util.ErrorLog.Println(tag+": could not publish preview", err.Error())
+			/**
+				Put the preview back so the user can retry committing it, but only when spotify
+				turned the request away before creating anything. A created playlist is reported
+				below instead, and a create that timed out may have been applied, either way
+				committing again could create a second playlist
+			**/
+			if result == nil && util.WasRejected(err) {
+				restoreCtx, restoreCancel := context.WithTimeout(context.Background(), 5*time.Second)
+				previewStore.SavePreview(restoreCtx, userId, previewToken, *draft)
+				restoreCancel()
+			}
 		}
+		publishedPlaylistResponse(c, result, err)
+	}
+}
 
-		if feature.Instrumentalness >= 0.5 {
-			instrumentalnessHigh = append(instrumentalnessHigh, feature.Instrumentalness)
-		} else {
-			instrumentalnessLow = append(instrumentalnessLow, feature.Instrumentalness)
-		}
+// CreatePlaylistJob queues a playlist generation to run in the background and returns
+// the id of the job, whose progress can be polled with GetPlaylistJob
+func CreatePlaylistJob() gin.HandlerFunc {
+	return queueJob("CREATE_PLAYLIST_JOB_HANDLER", service.JobTypePlaylist)
+}
 
-		if feature.Liveness >= 0.5 {
-			livenessHigh = append(livenessHigh, feature.Liveness)
-		} else {
-			livenessLow = append(livenessLow, feature.Liveness)
-		}
-		tempo = append(tempo, feature.Tempo)
+// CreateMixesJob queues the generation of a bundle of mixes, one playlist per taste cluster
+// found in the user's history, and returns the id of the job
+func CreateMixesJob() gin.HandlerFunc {
+	return queueJob("CREATE_MIXES_JOB_HANDLER", service.JobTypeMixes)
+}
 
-		if feature.Valence >= 0.5 {
-			valenceHigh = append(valenceHigh, feature.Valence)
-		} else {
-			valenceLow = append(valenceLow, feature.Valence)
+func queueJob(tag string, jobType string) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+		userId := c.Param("userId")
+		spec, ok := bindPlaylistSpec(c)
+		if !ok {
+			return
 		}
+		job, err := jobQueue.Enqueue(ctx, userId, jobType, spec)
+		if err != nil {
+			util.ErrorLog.Println(tag+": could not queue job", err.Error())
+			util.GenerateInternalServerErrorResponse(c, "Something went wrong please try again")
+			return
+		}
+		c.JSON(http.StatusAccepted, responses.APIResponse{
+			Status:    http.StatusAccepted,
+			Message:   "Job queued",
+			Timestamp: time.Now(),
+			Data:      gin.H{"jobId": job.Id, "type": job.Type, "status": job.Status},
+			Success:   true,
+		})
 	}
+}
 
-	if len(accousticnessLow) > len(accousticnessHigh) {
-		desiredAcousticness = getAverageValue(accousticnessLow)
-	} else {
-		desiredAcousticness = getAverageValue(accousticnessHigh)
+// GetPlaylistJob reports the status of each stage of a background playlist job
+func GetPlaylistJob() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
+		defer cancel()
+		job, err := jobQueue.GetJob(ctx, c.Param("userId"), c.Param("jobId"))
+		if err != nil {
+			util.GenerateErrorResponse(c, err)
+			return
+		}
+		c.JSON(http.StatusOK, responses.APIResponse{
+			Status:    http.StatusOK,
+			Message:   "Success",
+			Timestamp: time.Now(),
+			Data:      gin.H{"job": job},
+			Success:   true,
+		})
 	}
-	if len(danceabilityLow) > len(danceabilityHigh) {
-		desiredDanceability = getAverageValue(danceabilityLow)
-	} else {
-		desiredDanceability = getAverageValue(danceabilityHigh)
+}
+
+// bindPlaylistSpec reads the generation request from the body, writing a bad request
+// response and returning false when it is invalid. An empty body generates with defaults
+func bindPlaylistSpec(c *gin.Context) (service.PlaylistSpec, bool) {
+	var request requests.PlaylistGenerationRequest
+	if err := c.ShouldBindJSON(&request); err != nil && err != io.EOF {
+		util.GenerateBadRequestResponse(c, err.Error())
+		return service.PlaylistSpec{}, false
 	}
-	if len(energyLow) > len(energyHigh) {
-		desiredEnergy = getAverageValue(energyLow)
-	} else {
-		desiredEnergy = getAverageValue(energyHigh)
+	spec, err := service.NewPlaylistSpec(request)
+	if err != nil {
+		util.GenerateBadRequestResponse(c, err.Error())
+		return service.PlaylistSpec{}, false
 	}
-	if len(instrumentalnessLow) > len(instrumentalnessHigh) {
-		desiredInstrumentalness = getAverageValue(instrumentalnessLow)
-	} else {
-		desiredInstrumentalness = getAverageValue(instrumentalnessHigh)
+	return spec, true
+}
+
+func generatePlaylist(c *gin.Context, ctx context.Context, tag string, userId string, spec service.PlaylistSpec) {
+	sessionDetails, ok := getSpotifySession(c, ctx, tag, userId)
+	if !ok {
+		return
 	}
-	if len(livenessLow) > len(livenessHigh) {
-		desiredLiveness = getAverageValue(livenessLow)
-	} else {
-		desiredLiveness = getAverageValue(livenessHigh)
+	result, err := playlistGenerator.Generate(ctx, userId, sessionDetails.AccessToken, spec, nil)
+	if err != nil {
+		util.ErrorLog.Println(tag+": could not generate playlist", err.Error())
 	}
+	publishedPlaylistResponse(c, result, err)
+}
 
-	desiredTempo = getAverageValue(tempo)
-
-	if len(valenceLow) > len(valenceHigh) {
-		desiredValence = getAverageValue(valenceLow)
-	} else {
-		desiredValence = getAverageValue(valenceHigh)
+// publishedPlaylistResponse answers with the published playlist, or with err when publishing
+// failed. A playlist that was created even though adding its tracks or saving its profile
+// failed is sent along with the error, so the client can finish it by refreshing its profile
+// instead of creating another
+func publishedPlaylistResponse(c *gin.Context, result *service.PlaylistGenerationResult, err error) {
+	if err == nil {
+		generatePlaylistResponse(c, result)
+		return
 	}
-
-	recommendationConfig := models.RecommendationProfile{
-		Acousticness:     desiredAcousticness,
-		Danceability:     desiredDanceability,
-		Energy:           desiredEnergy,
-		Instrumentalness: desiredInstrumentalness,
-		Liveness:         desiredLiveness,
-		Valence:          desiredValence,
-		Tempo:            desiredTempo,
-		SeedArtists:      []string{},
-		SeedGenres:       []string{},
-		SeedTracks:       []string{},
+	if result == nil {
+		util.GenerateErrorResponse(c, err)
+		return
 	}
+	data := gin.H{
+		"playlist":             result.Playlist,
+		"recommendationConfig": result.Profile,
+		"snapshotId":           result.SnapshotId,
+	}
+	var partialError util.ApplicationPartialFailureError
+	if errors.As(err, &partialError) {
+		data["addedTracks"] = partialError.Succeeded
+		data["failedTracks"] = partialError.Failed
+	}
+	util.GenerateErrorResponseWithData(c, err, data)
+}
 
-	return &recommendationConfig
+func generatePlaylistResponse(c *gin.Context, result *service.PlaylistGenerationResult) {
+	c.JSON(http.StatusOK, responses.APIResponse{
+		Status:    http.StatusOK,
+		Message:   "Success",
+		Timestamp: time.Now(),
+		Data: gin.H{
+			"playlist":             result.Playlist,
+			"recommendations":      result.Recommendations,
+			"topTracks":            result.TopTracks,
+			"topArtists":           result.TopArtists,
+			"features":             result.Features,
+			"recommendationConfig": result.Profile,
+			"seedSource":           result.SeedSource,
+			"refinement":           result.Refinement,
+			"snapshotId":           result.SnapshotId,
+		},
+		Success: true,
+	})
 }
 
-func getAverageValue(values []float32) float32 {
-	if len(values) == 0 {
-		return 0.0
+// getSpotifySession retrieves a session for the user whose access token is still valid,
+// the token manager refreshes the spotify tokens when they are about to expire.
+// When no session can be produced the response is written and false is returned
+func getSpotifySession(c *gin.Context, ctx context.Context, tag string, userId string) (*models.Session, bool) {
+	sessionDetails, err := tokenManager.GetValidSession(ctx, userId)
+	if err == nil {
+		return sessionDetails, true
+	}
+	if notFoundError, ok := err.(util.ApplicationNotFoundError); ok {
+		c.JSON(
+			http.StatusNotFound,
+			responses.APIResponse{
+				Status:    http.StatusNotFound,
+				Message:   "Not found",
+				Timestamp: time.Now(),
+				Data:      gin.H{"error": notFoundError.Message},
+				Success:   false,
+			},
+		)
+		return nil, false
+	}
+	if authError, ok := err.(util.ApplicationAuthError); ok {
+		util.ErrorLog.Println(tag+": session could not be refreshed", authError.Message)
+		// ask client to redo authorization
+		redirectURL, err := prepareRedirectURI(c, ctx, false, userScopeProfile(ctx, tag, userId))
+		if err != nil {
+			util.ErrorLog.Println(tag+": could not prepare redirect uri", err.Error())
+			util.GenerateInternalServerErrorResponse(c, "Something went wrong please try again")
+			return nil, false
+		}
+		c.JSON(
+			http.StatusUnauthorized,
+			responses.APIResponse{
+				Status:    http.StatusUnauthorized,
+				Message:   "Re-authorization required",
+				Timestamp: time.Now(),
+				Data:      gin.H{"url": redirectURL},
+				Success:   false,
+			},
+		)
+		return nil, false
 	}
+	// an open breaker or a timed out refresh keeps its own status instead of becoming a 500
+	util.ErrorLog.Println(tag+": could not retrieve session", err.Error())
+	util.GenerateErrorResponse(c, err)
+	return nil, false
+}
 
-	var sum float32 = 0.0
-	for _, value := range values {
-		sum += value
+// userScopeProfile is the scope profile the user last authorized with, so re-authorizing
+// asks for the same permissions. Users who authorized before profiles were recorded get
+// the configured default
+func userScopeProfile(ctx context.Context, tag string, userId string) string {
+	var user models.User
+	if err := userCollection.FindOne(ctx, bson.M{"id": userId}).Decode(&user); err != nil {
+		util.ErrorLog.Println(tag+": could not retrieve user scope profile", err.Error())
+		return config.EnvDefaultScopeProfile()
 	}
+	if _, ok := config.ScopeProfile(user.ScopeProfile); !ok {
+		return config.EnvDefaultScopeProfile()
+	}
+	return user.ScopeProfile
+}
 
-	return sum / float32(len(values))
+// parseTasteBlend reads the time range blend a playlist should be built from. Clients either
+// name a preset with ?blend= or weigh the ranges themselves with ?short_term=, ?medium_term=
+// and ?long_term=. Without either, playlists are built from the short term as before
+func parseTasteBlend(c *gin.Context) (service.TasteBlend, error) {
+	if name := c.Query("blend"); len(name) != 0 {
+		blend, ok := service.TasteBlendPreset(name)
+		if !ok {
+			return blend, errors.New("Unknown blend " + name)
+		}
+		return blend, nil
+	}
+	var blend service.TasteBlend
+	weights := map[string]*float64{
+		"short_term":  &blend.ShortTerm,
+		"medium_term": &blend.MediumTerm,
+		"long_term":   &blend.LongTerm,
+	}
+	provided := false
+	for param, weight := range weights {
+		value := c.Query(param)
+		if len(value) == 0 {
+			continue
+		}
+		parsed, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			return blend, errors.New(param + " must be a number")
+		}
+		*weight = parsed
+		provided = true
+	}
+	if !provided {
+		return service.DefaultTasteBlend, nil
+	}
+	if err := blend.Validate(); err != nil {
+		return blend, err
+	}
+	return blend, nil
 }
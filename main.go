@@ -5,8 +5,10 @@ import (
 	"errors"
 	"log"
 	"mofe64/playlistGen/config"
+	"mofe64/playlistGen/handlers"
 	"mofe64/playlistGen/middleware"
 	"mofe64/playlistGen/routes"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
@@ -34,11 +36,30 @@ func main() {
 	routes.AuthorizationRoute(router)
 	// user routes
 	routes.UserRoute(router)
+	// catalogue routes
+	routes.CatalogueRoute(router)
+	// admin routes
+	routes.AdminRoute(router)
+
+	/**
+		Every request context derives from this base context. Cancelling it once the server
+		has stopped accepting connections cancels the outgoing spotify calls of requests
+		that are still in flight
+	**/
+	baseCtx, cancelBaseCtx := context.WithCancel(context.Background())
+
+	// background playlist jobs stop with the base context, unfinished ones resume on restart
+	handlers.StartPlaylistJobWorkers(baseCtx)
+	// sessions still encrypted with a previous key are moved to the active one
+	handlers.StartTokenKeyRotation(baseCtx)
 
 	// Create Custom Server
 	server := &http.Server{
 		Addr:    ":" + config.EnvHTTPPort(),
 		Handler: router,
+		BaseContext: func(_ net.Listener) context.Context {
+			return baseCtx
+		},
 	}
 
 	// Launch the server in a Goroutine (concurrent execution)
@@ -67,7 +88,10 @@ func main() {
 	defer cancel()
 
 	// Initiate a graceful shutdown of the server, allowing existing connections to complete
-	if err := server.Shutdown(ctx); err != nil {
+	err := server.Shutdown(ctx)
+	// requests still running after the grace period have their spotify calls cancelled
+	cancelBaseCtx()
+	if err != nil {
 		log.Fatal("Server forced to shutdown:", err)
 	}
 	log.Println("Server exiting ....")
@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"crypto/subtle"
+	"mofe64/playlistGen/config"
+	"net/http"
+
+	"github.com/gin-gonic/gin"
+)
+
+// RequireAdmin guards operational endpoints with the admin api key from config,
+// sent in the X-Admin-Key header. When no key is configured every request is rejected
+func RequireAdmin() gin.HandlerFunc {
+	adminKey := config.EnvAdminAPIKey()
+	return func(c *gin.Context) {
+		providedKey := c.GetHeader("X-Admin-Key")
+		if len(adminKey) == 0 || subtle.ConstantTimeCompare([]byte(providedKey), []byte(adminKey)) != 1 {
+			abortWithStatus(c, http.StatusUnauthorized, "Admin key required")
+			return
+		}
+		c.Next()
+	}
+}
@@ -4,44 +4,74 @@ import (
 	"context"
 	"mofe64/playlistGen/config"
 	"mofe64/playlistGen/data/responses"
+	"mofe64/playlistGen/service"
 	"mofe64/playlistGen/util"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/gin-gonic/gin"
 )
 
-var redis = config.RedisClient
+var jwtService = service.NewJWTService(config.JWTSecret())
+var revocationStore = service.NewTokenRevocationStore(config.RedisClient)
 var tag = "REQUIRE_AUTH_MIDDLEWARE"
 
+// RequireAuth authenticates a request using the bearer access token we issued in the
+// authorization code callback. When the route has a userId path variable the token's
+// subject must match it. Spotify session details are not loaded here, handlers retrieve
+// them through the token manager so that expired access tokens get refreshed before use
 func RequireAuth() gin.HandlerFunc {
 	return func(c *gin.Context) {
-		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+		ctx, cancel := context.WithTimeout(c.Request.Context(), 10*time.Second)
 		defer cancel()
-		userId := c.Param("userId")
-		if len(userId) == 0 {
-			c.AbortWithStatusJSON(http.StatusUnauthorized, responses.APIResponse{
-				Status:    http.StatusUnauthorized,
-				Message:   "User Id path variable required",
-				Timestamp: time.Now(),
-				Data:      gin.H{},
-				Success:   false,
-			})
+
+		authHeader := c.GetHeader("Authorization")
+		tokenString, found := strings.CutPrefix(authHeader, "Bearer ")
+		if !found || len(tokenString) == 0 {
+			abortWithStatus(c, http.StatusUnauthorized, "Bearer token required")
 			return
 		}
-		// update redis config creation to ping redis and panic not here
-		if err := redis.Ping(ctx).Err(); err != nil {
-			util.ErrorLog.Println(tag+": could not reach redis ...", err.Error())
-		} else {
-			val, err := redis.Get(ctx, userId).Result()
-			if err != nil {
-				util.ErrorLog.Println(tag+": Could not retrive from redis", err.Error())
-			} else {
-				c.Set("userDetails", val)
-			}
+
+		claims, err := jwtService.ValidateToken(tokenString, service.AccessTokenType)
+		if err != nil {
+			util.ErrorLog.Println(tag+": token validation failed", err.Error())
+			abortWithStatus(c, http.StatusUnauthorized, err.Error())
+			return
 		}
 
+		// tokens that were logged out or revoked are rejected even though they have not expired
+		revoked, err := revocationStore.IsRevoked(ctx, claims)
+		if err != nil {
+			util.ErrorLog.Println(tag+": could not check token revocation", err.Error())
+			abortWithStatus(c, http.StatusInternalServerError, "Something went wrong please try again")
+			return
+		}
+		if revoked {
+			abortWithStatus(c, http.StatusUnauthorized, "Token has been revoked")
+			return
+		}
+
+		// a valid token only grants access to the resources of the user it was issued to
+		if userId := c.Param("userId"); len(userId) != 0 && claims.Subject != userId {
+			util.ErrorLog.Println(tag+": token subject does not match user id", claims.Subject, userId)
+			abortWithStatus(c, http.StatusForbidden, "Token not valid for this user")
+			return
+		}
+
+		c.Set("userId", claims.Subject)
+		c.Set("claims", claims)
 		c.Next()
 	}
 
 }
+
+func abortWithStatus(c *gin.Context, status int, message string) {
+	c.AbortWithStatusJSON(status, responses.APIResponse{
+		Status:    status,
+		Message:   message,
+		Timestamp: time.Now(),
+		Data:      gin.H{},
+		Success:   false,
+	})
+}
@@ -0,0 +1,225 @@
+package recommendation
+
+import (
+	"math"
+	"mofe64/playlistGen/data/responses"
+	"sort"
+	"strconv"
+)
+
+const (
+	// clusters with fewer tracks than this are too thin to describe a taste and are dropped
+	minClusterSize = 5
+	maxIterations  = 50
+)
+
+// Cluster is a group of the user's tracks with similar audio features
+type Cluster struct {
+	Name     string               `json:"name"`
+	Features []responses.Features `json:"features"`
+}
+
+// clusterDimensions are the features tracks are compared on
+var clusterDimensions = []func(feature responses.Features) float64{
+	func(f responses.Features) float64 { return float64(f.Acousticness) },
+	func(f responses.Features) float64 { return float64(f.Danceability) },
+	func(f responses.Features) float64 { return float64(f.Energy) },
+	func(f responses.Features) float64 { return float64(f.Instrumentalness) },
+	func(f responses.Features) float64 { return float64(f.Liveness) },
+	func(f responses.Features) float64 { return float64(f.Speechiness) },
+	func(f responses.Features) float64 { return float64(f.Valence) },
+	func(f responses.Features) float64 { return float64(f.Tempo) },
+	func(f responses.Features) float64 { return float64(f.Loudness) },
+}
+
+// ClusterFeatures splits the tracks into at most k groups of similar tracks using k-means
+// over their features, each scaled to the range the user's tracks span so tempo and loudness
+// weigh as much as the rest. Clusters are seeded deterministically, starting from the user's
+// first track, so the same history always produces the same clusters. Clusters are named
+// after what their tracks have in common and returned largest first
+func ClusterFeatures(features []responses.Features, k int) []Cluster {
+	tracks := []responses.Features{}
+	for _, feature := range features {
+		if len(feature.Id) != 0 {
+			tracks = append(tracks, feature)
+		}
+	}
+	if k > len(tracks)/minClusterSize {
+		k = len(tracks) / minClusterSize
+	}
+	if k < 1 {
+		return []Cluster{}
+	}
+
+	vectors := normalize(tracks)
+	centroids := initialCentroids(vectors, k)
+	assignments := make([]int, len(vectors))
+	for iteration := 0; iteration < maxIterations; iteration++ {
+		changed := iteration == 0
+		for index, vector := range vectors {
+			nearest := nearestCentroid(vector, centroids)
+			if nearest != assignments[index] {
+				assignments[index] = nearest
+				changed = true
+			}
+		}
+		if !changed {
+			break
+		}
+		centroids = recomputeCentroids(vectors, assignments, centroids)
+	}
+
+	groups := make([][]responses.Features, k)
+	for index, track := range tracks {
+		groups[assignments[index]] = append(groups[assignments[index]], track)
+	}
+	clusters := []Cluster{}
+	for _, group := range groups {
+		if len(group) >= minClusterSize {
+			clusters = append(clusters, Cluster{Features: group})
+		}
+	}
+	sortClustersBySize(clusters)
+	nameClusters(clusters)
+	return clusters
+}
+
+func normalize(tracks []responses.Features) [][]float64 {
+	vectors := make([][]float64, len(tracks))
+	for index := range vectors {
+		vectors[index] = make([]float64, len(clusterDimensions))
+	}
+	for dimension, value := range clusterDimensions {
+		low, high := math.Inf(1), math.Inf(-1)
+		for _, track := range tracks {
+			low = math.Min(low, value(track))
+			high = math.Max(high, value(track))
+		}
+		for index, track := range tracks {
+			if high > low {
+				vectors[index][dimension] = (value(track) - low) / (high - low)
+			}
+		}
+	}
+	return vectors
+}
+
+// initialCentroids picks the first track, then repeatedly the track farthest from every
+// centroid picked so far
+func initialCentroids(vectors [][]float64, k int) [][]float64 {
+	centroids := [][]float64{append([]float64{}, vectors[0]...)}
+	for len(centroids) < k {
+		farthest, farthestDistance := 0, -1.0
+		for index, vector := range vectors {
+			distance := distance(vector, centroids[nearestCentroid(vector, centroids)])
+			if distance > farthestDistance {
+				farthest, farthestDistance = index, distance
+			}
+		}
+		centroids = append(centroids, append([]float64{}, vectors[farthest]...))
+	}
+	return centroids
+}
+
+func recomputeCentroids(vectors [][]float64, assignments []int, previous [][]float64) [][]float64 {
+	sums := make([][]float64, len(previous))
+	counts := make([]int, len(previous))
+	for index := range sums {
+		sums[index] = make([]float64, len(clusterDimensions))
+	}
+	for index, vector := range vectors {
+		counts[assignments[index]]++
+		for dimension, value := range vector {
+			sums[assignments[index]][dimension] += value
+		}
+	}
+	centroids := make([][]float64, len(previous))
+	for index := range centroids {
+		// a centroid nothing was assigned to stays where it was
+		if counts[index] == 0 {
+			centroids[index] = previous[index]
+			continue
+		}
+		for dimension := range sums[index] {
+			sums[index][dimension] /= float64(counts[index])
+		}
+		centroids[index] = sums[index]
+	}
+	return centroids
+}
+
+func nearestCentroid(vector []float64, centroids [][]float64) int {
+	nearest, nearestDistance := 0, math.Inf(1)
+	for index, centroid := range centroids {
+		if d := distance(vector, centroid); d < nearestDistance {
+			nearest, nearestDistance = index, d
+		}
+	}
+	return nearest
+}
+
+func distance(a []float64, b []float64) float64 {
+	var sum float64
+	for index := range a {
+		sum += (a[index] - b[index]) * (a[index] - b[index])
+	}
+	return math.Sqrt(sum)
+}
+
+func sortClustersBySize(clusters []Cluster) {
+	sort.SliceStable(clusters, func(i, j int) bool {
+		return len(clusters[i].Features) > len(clusters[j].Features)
+	})
+}
+
+// nameClusters describes each cluster by its average energy, mood and most distinctive
+// style, e.g. "high-energy dance" or "acoustic chill". Repeated names are numbered
+func nameClusters(clusters []Cluster) {
+	used := map[string]int{}
+	for index := range clusters {
+		name := describe(clusters[index].Features)
+		used[name]++
+		if used[name] > 1 {
+			name += " " + strconv.Itoa(used[name])
+		}
+		clusters[index].Name = name
+	}
+}
+
+func describe(features []responses.Features) string {
+	var acousticness, danceability, energy, instrumentalness, speechiness, valence []float32
+	for _, feature := range features {
+		acousticness = append(acousticness, feature.Acousticness)
+		danceability = append(danceability, feature.Danceability)
+		energy = append(energy, feature.Energy)
+		instrumentalness = append(instrumentalness, feature.Instrumentalness)
+		speechiness = append(speechiness, feature.Speechiness)
+		valence = append(valence, feature.Valence)
+	}
+
+	style := "vibes"
+	switch {
+	case average(speechiness) >= 0.33:
+		style = "rap"
+	case average(instrumentalness) >= 0.5:
+		style = "instrumental"
+	case average(acousticness) >= 0.6:
+		style = "acoustic"
+	case average(danceability) >= 0.65:
+		style = "dance"
+	}
+
+	switch {
+	case average(energy) >= 0.7:
+		return "high-energy " + style
+	case average(energy) <= 0.4 && style == "vibes":
+		return "chill vibes"
+	case average(energy) <= 0.4:
+		return style + " chill"
+	case average(valence) >= 0.6:
+		return "upbeat " + style
+	case average(valence) <= 0.35:
+		return "moody " + style
+	}
+	return "mellow " + style
+}
@@ -0,0 +1,174 @@
+package recommendation
+
+import (
+	"mofe64/playlistGen/data/responses"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// repeated gives n tracks with the same features, ids prefixed with prefix
+func repeated(prefix string, n int, feature responses.Features) []responses.Features {
+	features := []responses.Features{}
+	for index := 0; index < n; index++ {
+		feature.Id = prefix + strconv.Itoa(index)
+		features = append(features, feature)
+	}
+	return features
+}
+
+var (
+	danceTrack    = responses.Features{Energy: 0.9, Danceability: 0.8, Valence: 0.7, Tempo: 125, Loudness: -5}
+	acousticTrack = responses.Features{Energy: 0.2, Acousticness: 0.9, Valence: 0.4, Tempo: 90, Loudness: -14}
+	rapTrack      = responses.Features{Energy: 0.6, Speechiness: 0.5, Danceability: 0.7, Valence: 0.5, Tempo: 95, Loudness: -7}
+)
+
+func concat(groups ...[]responses.Features) []responses.Features {
+	features := []responses.Features{}
+	for _, group := range groups {
+		features = append(features, group...)
+	}
+	return features
+}
+
+func clusterSummary(clusters []Cluster) map[string]int {
+	summary := map[string]int{}
+	for _, cluster := range clusters {
+		summary[cluster.Name] = len(cluster.Features)
+	}
+	return summary
+}
+
+func TestClusterFeatures(t *testing.T) {
+	tests := []struct {
+		name     string
+		features []responses.Features
+		k        int
+		want     []string
+		sizes    map[string]int
+	}{
+		{
+			name:     "too few tracks for a cluster",
+			features: repeated("dance", minClusterSize-1, danceTrack),
+			k:        3,
+			want:     []string{},
+			sizes:    map[string]int{},
+		},
+		{
+			name:     "separate tastes make separate clusters, largest first",
+			features: concat(repeated("acoustic", 5, acousticTrack), repeated("dance", 7, danceTrack)),
+			k:        2,
+			want:     []string{"high-energy dance", "acoustic chill"},
+			sizes:    map[string]int{"high-energy dance": 7, "acoustic chill": 5},
+		},
+		{
+			// 12 tracks only have room for 2 clusters of the minimum size
+			name:     "k is capped by the number of tracks",
+			features: concat(repeated("dance", 7, danceTrack), repeated("acoustic", 5, acousticTrack)),
+			k:        5,
+			want:     []string{"high-energy dance", "acoustic chill"},
+			sizes:    map[string]int{"high-energy dance": 7, "acoustic chill": 5},
+		},
+		{
+			name:     "clusters below the minimum size are dropped",
+			features: concat(repeated("dance", 7, danceTrack), repeated("acoustic", 5, acousticTrack), repeated("rap", 3, rapTrack)),
+			k:        3,
+			want:     []string{"high-energy dance", "acoustic chill"},
+			sizes:    map[string]int{"high-energy dance": 7, "acoustic chill": 5},
+		},
+		{
+			name:     "tracks without features are ignored",
+			features: concat(repeated("dance", 7, danceTrack), []responses.Features{{}, {}, {}}),
+			k:        2,
+			want:     []string{"high-energy dance"},
+			sizes:    map[string]int{"high-energy dance": 7},
+		},
+		{
+			// the tempo keeps these apart even though they are described the same way
+			name: "repeated names are numbered",
+			features: concat(
+				repeated("fast", 6, responses.Features{Energy: 0.9, Danceability: 0.8, Tempo: 170}),
+				repeated("slow", 5, responses.Features{Energy: 0.9, Danceability: 0.8, Tempo: 80}),
+			),
+			k:     2,
+			want:  []string{"high-energy dance", "high-energy dance 2"},
+			sizes: map[string]int{"high-energy dance": 6, "high-energy dance 2": 5},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			clusters := ClusterFeatures(tt.features, tt.k)
+			names := []string{}
+			for _, cluster := range clusters {
+				names = append(names, cluster.Name)
+			}
+			if !reflect.DeepEqual(names, tt.want) {
+				t.Errorf("cluster names = %v, want %v", names, tt.want)
+			}
+			if got := clusterSummary(clusters); !reflect.DeepEqual(got, tt.sizes) {
+				t.Errorf("cluster sizes = %v, want %v", got, tt.sizes)
+			}
+		})
+	}
+}
+
+func TestClusterFeaturesKeepsTracksTogether(t *testing.T) {
+	clusters := ClusterFeatures(concat(repeated("dance", 7, danceTrack), repeated("acoustic", 6, acousticTrack)), 2)
+	if len(clusters) != 2 {
+		t.Fatalf("got %d clusters, want 2", len(clusters))
+	}
+	for _, cluster := range clusters {
+		prefix := cluster.Features[0].Id[:len(cluster.Features[0].Id)-1]
+		for _, feature := range cluster.Features {
+			if feature.Id[:len(feature.Id)-1] != prefix {
+				t.Errorf("cluster %q mixes %s and %s tracks", cluster.Name, prefix, feature.Id)
+			}
+		}
+	}
+}
+
+func TestClusterFeaturesIsDeterministic(t *testing.T) {
+	features := []responses.Features{}
+	for index := 0; index < 30; index++ {
+		// spread tracks over the feature space without any randomness
+		step := float32(index%10) / 10
+		features = append(features, responses.Features{
+			Id:           strconv.Itoa(index),
+			Energy:       step,
+			Danceability: 1 - step,
+			Acousticness: float32(index%3) / 3,
+			Valence:      float32(index%7) / 7,
+			Tempo:        80 + float32(index*4),
+			Loudness:     -float32(index % 12),
+		})
+	}
+	first := ClusterFeatures(features, 3)
+	for run := 0; run < 5; run++ {
+		if again := ClusterFeatures(features, 3); !reflect.DeepEqual(first, again) {
+			t.Fatalf("clustering the same tracks twice gave different clusters")
+		}
+	}
+}
+
+func TestDescribe(t *testing.T) {
+	tests := []struct {
+		feature responses.Features
+		want    string
+	}{
+		{responses.Features{Energy: 0.9, Danceability: 0.8}, "high-energy dance"},
+		{responses.Features{Energy: 0.8, Speechiness: 0.5, Danceability: 0.8}, "high-energy rap"},
+		{responses.Features{Energy: 0.3, Acousticness: 0.8}, "acoustic chill"},
+		{responses.Features{Energy: 0.2}, "chill vibes"},
+		{responses.Features{Energy: 0.3, Instrumentalness: 0.9, Acousticness: 0.9}, "instrumental chill"},
+		{responses.Features{Energy: 0.5, Valence: 0.8}, "upbeat vibes"},
+		{responses.Features{Energy: 0.5, Valence: 0.2, Acousticness: 0.7}, "moody acoustic"},
+		{responses.Features{Energy: 0.5, Valence: 0.5}, "mellow vibes"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.want, func(t *testing.T) {
+			if got := describe([]responses.Features{tt.feature}); got != tt.want {
+				t.Errorf("describe = %q, want %q", got, tt.want)
+			}
+		})
+	}
+}
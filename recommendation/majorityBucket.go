@@ -0,0 +1,38 @@
+package recommendation
+
+import (
+	"mofe64/playlistGen/data/models"
+	"mofe64/playlistGen/data/responses"
+)
+
+const MajorityBucketStrategyName = "majority_bucket"
+
+// majorityBucketStrategy splits each attribute measured between 0 and 1 into a low and a
+// high half at 0.5, and targets the average of whichever half holds most of the tracks,
+// within the band those tracks span. Attributes on other scales target the average of
+// every track
+type majorityBucketStrategy struct{}
+
+func (majorityBucketStrategy) Name() string {
+	return MajorityBucketStrategyName
+}
+
+func (s majorityBucketStrategy) BuildProfile(features []responses.Features, topTracks []models.Item, topArtists []models.Item) *models.RecommendationProfile {
+	return buildProfile(s.Name(), features, topTracks, majorityBucket, targetOnly(average))
+}
+
+func majorityBucket(values []float32) models.AttributeRange {
+	var low []float32
+	var high []float32
+	for _, value := range values {
+		if value >= 0.5 {
+			high = append(high, value)
+		} else {
+			low = append(low, value)
+		}
+	}
+	if len(low) > len(high) {
+		return banded(average(low), low)
+	}
+	return banded(average(high), high)
+}
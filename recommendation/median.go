@@ -0,0 +1,34 @@
+package recommendation
+
+import (
+	"mofe64/playlistGen/data/models"
+	"mofe64/playlistGen/data/responses"
+)
+
+const MedianStrategyName = "median"
+
+// the band median profiles allow, leaving out the most outlying tenth of tracks on each side
+const (
+	medianBandLow  = 0.1
+	medianBandHigh = 0.9
+)
+
+// medianStrategy targets the median of each attribute, which a few outlying tracks cannot
+// drag around, within the band most of the user's tracks fall in
+type medianStrategy struct{}
+
+func (medianStrategy) Name() string {
+	return MedianStrategyName
+}
+
+func (s medianStrategy) BuildProfile(features []responses.Features, topTracks []models.Item, topArtists []models.Item) *models.RecommendationProfile {
+	return buildProfile(s.Name(), features, topTracks, medianRange, medianRange)
+}
+
+func medianRange(values []float32) models.AttributeRange {
+	sortedValues := sorted(values)
+	target := percentile(sortedValues, 0.5)
+	low := percentile(sortedValues, medianBandLow)
+	high := percentile(sortedValues, medianBandHigh)
+	return models.AttributeRange{Min: &low, Max: &high, Target: &target}
+}
@@ -0,0 +1,37 @@
+package recommendation
+
+import (
+	"mofe64/playlistGen/data/models"
+	"mofe64/playlistGen/data/responses"
+)
+
+const PercentileBandStrategyName = "percentile_band"
+
+// the band covers the middle half of the user's tracks
+const (
+	defaultBandLow  = 0.25
+	defaultBandHigh = 0.75
+)
+
+// percentileBandStrategy limits each attribute to the band between two percentiles and
+// targets the middle of it, aiming at where most of the user's tracks sit rather than at
+// any single value
+type percentileBandStrategy struct {
+	low  float64
+	high float64
+}
+
+func (percentileBandStrategy) Name() string {
+	return PercentileBandStrategyName
+}
+
+func (s percentileBandStrategy) BuildProfile(features []responses.Features, topTracks []models.Item, topArtists []models.Item) *models.RecommendationProfile {
+	percentileBand := func(values []float32) models.AttributeRange {
+		sortedValues := sorted(values)
+		low := percentile(sortedValues, s.low)
+		high := percentile(sortedValues, s.high)
+		target := (low + high) / 2
+		return models.AttributeRange{Min: &low, Max: &high, Target: &target}
+	}
+	return buildProfile(s.Name(), features, topTracks, percentileBand, percentileBand)
+}
@@ -0,0 +1,37 @@
+package recommendation
+
+import (
+	"math"
+	"sort"
+)
+
+func average(values []float32) float32 {
+	if len(values) == 0 {
+		return 0.0
+	}
+
+	var sum float32 = 0.0
+	for _, value := range values {
+		sum += value
+	}
+
+	return sum / float32(len(values))
+}
+
+func sorted(values []float32) []float32 {
+	sortedValues := append([]float32{}, values...)
+	sort.Slice(sortedValues, func(i, j int) bool { return sortedValues[i] < sortedValues[j] })
+	return sortedValues
+}
+
+// percentile linearly interpolates the p-th percentile, p in [0, 1], of sorted values
+func percentile(sortedValues []float32, p float64) float32 {
+	if len(sortedValues) == 0 {
+		return 0.0
+	}
+	rank := p * float64(len(sortedValues)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	weight := float32(rank - float64(lower))
+	return sortedValues[lower] + (sortedValues[upper]-sortedValues[lower])*weight
+}
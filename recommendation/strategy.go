@@ -0,0 +1,158 @@
+package recommendation
+
+import (
+	"math"
+	"mofe64/playlistGen/data/models"
+	"mofe64/playlistGen/data/responses"
+	"sort"
+)
+
+// RecommendationStrategy turns the audio features of a user's tracks into the targets of a
+// recommendation profile. The user's top tracks and artists are passed along for strategies
+// that want more than the audio features to go on
+type RecommendationStrategy interface {
+	Name() string
+	BuildProfile(features []responses.Features, topTracks []models.Item, topArtists []models.Item) *models.RecommendationProfile
+}
+
+// DefaultStrategy is the strategy profiles were built with before strategies could be chosen
+const DefaultStrategy = MajorityBucketStrategyName
+
+var strategies = map[string]RecommendationStrategy{}
+
+func register(strategy RecommendationStrategy) {
+	strategies[strategy.Name()] = strategy
+}
+
+func init() {
+	register(majorityBucketStrategy{})
+	register(medianStrategy{})
+	register(trimmedMeanStrategy{trim: defaultTrimRatio})
+	register(percentileBandStrategy{low: defaultBandLow, high: defaultBandHigh})
+}
+
+// Get returns the strategy registered under name
+func Get(name string) (RecommendationStrategy, bool) {
+	strategy, ok := strategies[name]
+	return strategy, ok
+}
+
+// Names returns the names of every registered strategy in alphabetical order
+func Names() []string {
+	names := []string{}
+	for name := range strategies {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// attributeFunc derives the range of one attribute from the values the user's tracks have for it
+type attributeFunc func(values []float32) models.AttributeRange
+
+// buildProfile derives a range for every tunable attribute. unitAttribute handles the
+// attributes spotify measures between 0 and 1, scaledAttribute those on other scales such as
+// tempo, loudness and popularity. Key, mode and time signature are categories rather than
+// measurements, so they always target the most common value without a band
+func buildProfile(name string, features []responses.Features, topTracks []models.Item, unitAttribute attributeFunc, scaledAttribute attributeFunc) *models.RecommendationProfile {
+	var acousticness, danceability, energy, instrumentalness, liveness, loudness, speechiness, tempo, valence []float32
+	var key, mode, timeSignature, popularity []float32
+	for _, feature := range features {
+		// spotify has no features for some tracks and returns an empty entry for them
+		if len(feature.Id) == 0 {
+			continue
+		}
+		acousticness = append(acousticness, feature.Acousticness)
+		danceability = append(danceability, feature.Danceability)
+		energy = append(energy, feature.Energy)
+		instrumentalness = append(instrumentalness, feature.Instrumentalness)
+		liveness = append(liveness, feature.Liveness)
+		loudness = append(loudness, feature.Loudness)
+		speechiness = append(speechiness, feature.Speechiness)
+		tempo = append(tempo, feature.Tempo)
+		valence = append(valence, feature.Valence)
+		// -1 means spotify detected no key, which is not a key recommendations can target
+		if feature.Key != -1 {
+			key = append(key, float32(feature.Key))
+		}
+		mode = append(mode, float32(feature.Mode))
+		timeSignature = append(timeSignature, float32(feature.TimeSignature))
+	}
+	for _, track := range topTracks {
+		if track.Popularity > 0 {
+			popularity = append(popularity, float32(track.Popularity))
+		}
+	}
+	return &models.RecommendationProfile{
+		Strategy:         name,
+		Acousticness:     whenPresent(acousticness, unitAttribute),
+		Danceability:     whenPresent(danceability, unitAttribute),
+		Energy:           whenPresent(energy, unitAttribute),
+		Instrumentalness: whenPresent(instrumentalness, unitAttribute),
+		Liveness:         whenPresent(liveness, unitAttribute),
+		Speechiness:      whenPresent(speechiness, unitAttribute),
+		Valence:          whenPresent(valence, unitAttribute),
+		Loudness:         whenPresent(loudness, scaledAttribute),
+		Tempo:            whenPresent(tempo, scaledAttribute),
+		Popularity:       whenPresent(popularity, rounded(scaledAttribute)),
+		Key:              whenPresent(key, mostCommon),
+		Mode:             whenPresent(mode, mostCommon),
+		TimeSignature:    whenPresent(timeSignature, mostCommon),
+		SeedArtists:      []string{},
+		SeedGenres:       []string{},
+		SeedTracks:       []string{},
+	}
+}
+
+// whenPresent leaves the attribute unconstrained when there is nothing to derive it from
+func whenPresent(values []float32, attribute attributeFunc) models.AttributeRange {
+	if len(values) == 0 {
+		return models.AttributeRange{}
+	}
+	return attribute(values)
+}
+
+// rounded rounds the bounds of attributes spotify only accepts whole numbers for
+func rounded(attribute attributeFunc) attributeFunc {
+	return func(values []float32) models.AttributeRange {
+		attributeRange := attribute(values)
+		for _, bound := range []*float32{attributeRange.Min, attributeRange.Max, attributeRange.Target} {
+			if bound != nil {
+				*bound = float32(math.Round(float64(*bound)))
+			}
+		}
+		return attributeRange
+	}
+}
+
+// targetOnly aims at a single value without a band
+func targetOnly(target func(values []float32) float32) attributeFunc {
+	return func(values []float32) models.AttributeRange {
+		value := target(values)
+		return models.AttributeRange{Target: &value}
+	}
+}
+
+// banded aims at target within the band between the lowest and highest of bandValues
+func banded(target float32, bandValues []float32) models.AttributeRange {
+	attributeRange := models.AttributeRange{Target: &target}
+	if len(bandValues) != 0 {
+		sortedValues := sorted(bandValues)
+		low, high := sortedValues[0], sortedValues[len(sortedValues)-1]
+		attributeRange.Min = &low
+		attributeRange.Max = &high
+	}
+	return attributeRange
+}
+
+func mostCommon(values []float32) models.AttributeRange {
+	counts := map[float32]int{}
+	var common float32
+	for _, value := range values {
+		counts[value]++
+		if counts[value] > counts[common] || (counts[value] == counts[common] && value < common) {
+			common = value
+		}
+	}
+	return models.AttributeRange{Target: &common}
+}
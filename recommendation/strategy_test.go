@@ -0,0 +1,193 @@
+package recommendation
+
+import (
+	"math"
+	"mofe64/playlistGen/data/models"
+	"mofe64/playlistGen/data/responses"
+	"reflect"
+	"strconv"
+	"testing"
+)
+
+// energyFeatures gives a track per value, with that energy and every other feature at zero
+func energyFeatures(values ...float32) []responses.Features {
+	features := []responses.Features{}
+	for index, value := range values {
+		features = append(features, responses.Features{Id: "track" + strconv.Itoa(index), Energy: value})
+	}
+	return features
+}
+
+func bound(value float32) *float32 {
+	return &value
+}
+
+func assertRange(t *testing.T, attribute string, got models.AttributeRange, want models.AttributeRange) {
+	t.Helper()
+	bounds := []struct {
+		name      string
+		got, want *float32
+	}{
+		{"min", got.Min, want.Min},
+		{"max", got.Max, want.Max},
+		{"target", got.Target, want.Target},
+	}
+	for _, b := range bounds {
+		switch {
+		case b.want == nil && b.got != nil:
+			t.Errorf("%s %s = %v, want unset", attribute, b.name, *b.got)
+		case b.want != nil && b.got == nil:
+			t.Errorf("%s %s is unset, want %v", attribute, b.name, *b.want)
+		case b.want != nil && math.Abs(float64(*b.got-*b.want)) > 1e-4:
+			t.Errorf("%s %s = %v, want %v", attribute, b.name, *b.got, *b.want)
+		}
+	}
+}
+
+func TestStrategyBands(t *testing.T) {
+	tests := []struct {
+		strategy string
+		energy   []float32
+		want     models.AttributeRange
+	}{
+		{
+			// three of five tracks are in the high half, so the band is theirs
+			strategy: MajorityBucketStrategyName,
+			energy:   []float32{0.1, 0.2, 0.6, 0.8, 0.9},
+			want:     models.AttributeRange{Min: bound(0.6), Max: bound(0.9), Target: bound(0.76667)},
+		},
+		{
+			strategy: MajorityBucketStrategyName,
+			energy:   []float32{0.1, 0.2, 0.3, 0.9},
+			want:     models.AttributeRange{Min: bound(0.1), Max: bound(0.3), Target: bound(0.2)},
+		},
+		{
+			strategy: MedianStrategyName,
+			energy:   []float32{0.9, 0.1, 0.6, 0.2, 0.8},
+			want:     models.AttributeRange{Min: bound(0.14), Max: bound(0.86), Target: bound(0.6)},
+		},
+		{
+			// too few tracks to trim any
+			strategy: TrimmedMeanStrategyName,
+			energy:   []float32{0.1, 0.2, 0.6, 0.8, 0.9},
+			want:     models.AttributeRange{Min: bound(0.1), Max: bound(0.9), Target: bound(0.52)},
+		},
+		{
+			strategy: TrimmedMeanStrategyName,
+			energy:   []float32{1.0, 0.3, 0.3, 0.4, 0.4, 0.5, 0.5, 0.6, 0.6, 0.05},
+			want:     models.AttributeRange{Min: bound(0.3), Max: bound(0.6), Target: bound(0.45)},
+		},
+		{
+			strategy: PercentileBandStrategyName,
+			energy:   []float32{0.1, 0.2, 0.6, 0.8, 0.9},
+			want:     models.AttributeRange{Min: bound(0.2), Max: bound(0.8), Target: bound(0.5)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			strategy, ok := Get(tt.strategy)
+			if !ok {
+				t.Fatalf("strategy %s is not registered", tt.strategy)
+			}
+			profile := strategy.BuildProfile(energyFeatures(tt.energy...), nil, nil)
+			if profile.Strategy != tt.strategy {
+				t.Errorf("profile strategy = %q, want %q", profile.Strategy, tt.strategy)
+			}
+			assertRange(t, "energy", profile.Energy, tt.want)
+		})
+	}
+}
+
+func TestStrategyScaledAttributes(t *testing.T) {
+	features := []responses.Features{
+		{Id: "a", Tempo: 100},
+		{Id: "b", Tempo: 120},
+		{Id: "c", Tempo: 140},
+	}
+	topTracks := []models.Item{{Popularity: 50}, {Popularity: 61}, {Popularity: 0}}
+	tests := []struct {
+		strategy   string
+		tempo      models.AttributeRange
+		popularity models.AttributeRange
+	}{
+		{
+			// attributes off the 0 to 1 scale can't be split in halves, they target the average
+			strategy:   MajorityBucketStrategyName,
+			tempo:      models.AttributeRange{Target: bound(120)},
+			popularity: models.AttributeRange{Target: bound(56)},
+		},
+		{
+			strategy:   MedianStrategyName,
+			tempo:      models.AttributeRange{Min: bound(104), Max: bound(136), Target: bound(120)},
+			popularity: models.AttributeRange{Min: bound(51), Max: bound(60), Target: bound(56)},
+		},
+		{
+			strategy:   PercentileBandStrategyName,
+			tempo:      models.AttributeRange{Min: bound(110), Max: bound(130), Target: bound(120)},
+			popularity: models.AttributeRange{Min: bound(53), Max: bound(58), Target: bound(56)},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.strategy, func(t *testing.T) {
+			strategy, _ := Get(tt.strategy)
+			profile := strategy.BuildProfile(features, topTracks, nil)
+			assertRange(t, "tempo", profile.Tempo, tt.tempo)
+			assertRange(t, "popularity", profile.Popularity, tt.popularity)
+		})
+	}
+}
+
+func TestStrategyCategories(t *testing.T) {
+	tests := []struct {
+		name string
+		keys []int
+		want models.AttributeRange
+	}{
+		{name: "most common key", keys: []int{5, 2, 5, 7}, want: models.AttributeRange{Target: bound(5)}},
+		{name: "ties go to the lowest key", keys: []int{5, 2}, want: models.AttributeRange{Target: bound(2)}},
+		{name: "no detected key is ignored", keys: []int{-1, -1, -1, 5}, want: models.AttributeRange{Target: bound(5)}},
+		{name: "no key detected at all", keys: []int{-1, -1}, want: models.AttributeRange{}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			features := []responses.Features{}
+			for index, key := range tt.keys {
+				features = append(features, responses.Features{Id: strconv.Itoa(index), Key: key, Mode: 1, TimeSignature: 4})
+			}
+			for _, name := range Names() {
+				strategy, _ := Get(name)
+				profile := strategy.BuildProfile(features, nil, nil)
+				assertRange(t, name+" key", profile.Key, tt.want)
+				assertRange(t, name+" mode", profile.Mode, models.AttributeRange{Target: bound(1)})
+				assertRange(t, name+" time_signature", profile.TimeSignature, models.AttributeRange{Target: bound(4)})
+			}
+		})
+	}
+}
+
+func TestStrategyWithoutFeatures(t *testing.T) {
+	// spotify returns an empty entry for tracks it has no features for
+	features := []responses.Features{{}, {}}
+	for _, name := range Names() {
+		strategy, _ := Get(name)
+		profile := strategy.BuildProfile(features, nil, nil)
+		for attribute, attributeRange := range profile.Attributes() {
+			if attributeRange.IsSet() {
+				t.Errorf("%s %s is set without any features to derive it from", name, attribute)
+			}
+		}
+	}
+}
+
+func TestStrategyRegistry(t *testing.T) {
+	want := []string{MajorityBucketStrategyName, MedianStrategyName, PercentileBandStrategyName, TrimmedMeanStrategyName}
+	if got := Names(); !reflect.DeepEqual(got, want) {
+		t.Errorf("Names() = %v, want %v", got, want)
+	}
+	if _, ok := Get(DefaultStrategy); !ok {
+		t.Errorf("default strategy %s is not registered", DefaultStrategy)
+	}
+	if _, ok := Get("unknown"); ok {
+		t.Error("Get found an unregistered strategy")
+	}
+}
@@ -0,0 +1,31 @@
+package recommendation
+
+import (
+	"mofe64/playlistGen/data/models"
+	"mofe64/playlistGen/data/responses"
+)
+
+const TrimmedMeanStrategyName = "trimmed_mean"
+
+// share of tracks dropped from each end before averaging
+const defaultTrimRatio = 0.1
+
+// trimmedMeanStrategy drops the highest and lowest values of each attribute and targets the
+// average of the rest, within the band the remaining tracks span
+type trimmedMeanStrategy struct {
+	trim float64
+}
+
+func (trimmedMeanStrategy) Name() string {
+	return TrimmedMeanStrategyName
+}
+
+func (s trimmedMeanStrategy) BuildProfile(features []responses.Features, topTracks []models.Item, topArtists []models.Item) *models.RecommendationProfile {
+	trimmedMean := func(values []float32) models.AttributeRange {
+		sortedValues := sorted(values)
+		drop := int(float64(len(sortedValues)) * s.trim)
+		kept := sortedValues[drop : len(sortedValues)-drop]
+		return banded(average(kept), kept)
+	}
+	return buildProfile(s.Name(), features, topTracks, trimmedMean, trimmedMean)
+}
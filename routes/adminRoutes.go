@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"mofe64/playlistGen/handlers"
+	"mofe64/playlistGen/middleware"
+
+	"github.com/gin-gonic/gin"
+)
+
+func AdminRoute(router *gin.Engine) {
+	adminRoutes := router.Group("api/v1/admin", middleware.RequireAdmin())
+	{
+		adminRoutes.GET("/circuit_breakers", handlers.GetCircuitBreakers())
+	}
+}
@@ -2,6 +2,7 @@ package routes
 
 import (
 	"mofe64/playlistGen/handlers"
+	"mofe64/playlistGen/middleware"
 
 	"github.com/gin-gonic/gin"
 )
@@ -12,5 +13,8 @@ func AuthorizationRoute(router *gin.Engine) {
 		authorizationRoutes.GET("/client_cred", handlers.GetAccessToken())
 		authorizationRoutes.GET("/auth_code", handlers.PrepareAuthCodeURI())
 		authorizationRoutes.GET("/auth_code_callback", handlers.AuthorizationCodeCallBack())
+		authorizationRoutes.POST("/refresh", handlers.RefreshToken())
+		authorizationRoutes.POST("/logout", middleware.RequireAuth(), handlers.Logout())
+		authorizationRoutes.POST("/revoke_all", middleware.RequireAuth(), handlers.RevokeAllSessions())
 	}
 }
@@ -0,0 +1,15 @@
+package routes
+
+import (
+	"mofe64/playlistGen/handlers"
+
+	"github.com/gin-gonic/gin"
+)
+
+func CatalogueRoute(router *gin.Engine) {
+	catalogueRoutes := router.Group("api/v1/catalogue")
+	{
+		catalogueRoutes.GET("/search", handlers.SearchCatalogue())
+		catalogueRoutes.GET("/artists/:artistId", handlers.GetArtist())
+	}
+}
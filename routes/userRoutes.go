@@ -11,5 +11,18 @@ func UserRoute(router *gin.Engine) {
 	userRoutes := router.Group("api/v1/user", middleware.RequireAuth())
 	{
 		userRoutes.GET("/:userId/create_playlist", handlers.CreatePlaylist())
+		userRoutes.POST("/:userId/playlists", handlers.GeneratePlaylist())
+		userRoutes.POST("/:userId/playlists/stream", handlers.StreamPlaylistGeneration())
+		userRoutes.POST("/:userId/playlists/preview", handlers.PreviewPlaylist())
+		userRoutes.POST("/:userId/playlists/preview/:previewToken/commit", handlers.CommitPlaylistPreview())
+		userRoutes.POST("/:userId/jobs", handlers.CreatePlaylistJob())
+		userRoutes.POST("/:userId/mixes", handlers.CreateMixesJob())
+		userRoutes.GET("/:userId/jobs/:jobId", handlers.GetPlaylistJob())
+		userRoutes.POST("/:userId/jobs/:jobId/stream_ticket", handlers.CreateJobStreamTicket())
+		userRoutes.GET("/:userId/profiles", handlers.ListProfiles())
+		userRoutes.GET("/:userId/profiles/:profileId", handlers.GetProfile())
+		userRoutes.POST("/:userId/profiles/:profileId/regenerate", handlers.RegenerateProfile())
 	}
+	// EventSource cannot send the bearer token, the stream is authorized by its ticket instead
+	router.GET("api/v1/user/:userId/jobs/:jobId/events", handlers.StreamJobEvents())
 }
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"mofe64/playlistGen/util"
+	"sync"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// refresh the app token this long before it expires
+const appTokenRefreshWindow = 2 * time.Minute
+
+// upper bound on a single refresh request, including retries
+const appTokenRefreshTimeout = 30 * time.Second
+
+// how long to wait before trying again when a background refresh fails
+const appTokenRetryInterval = 30 * time.Second
+
+// AppTokenProvider hands out the app level access token obtained with the client
+// credentials flow. The token is cached in the application context until shortly before
+// it expires and kept fresh in the background, so catalogue requests that don't act on
+// behalf of a user never have to wait on spotify's token endpoint
+type AppTokenProvider interface {
+	GetAppAccessToken(ctx context.Context) (string, time.Time, error)
+}
+
+type appTokenProvider struct {
+	spotifyService SpotifyService
+	appContext     util.ApplicationContext
+	// concurrent callers that find the token missing or stale share one refresh request
+	refreshGroup     singleflight.Group
+	startRefreshLoop sync.Once
+}
+
+func NewAppTokenProvider(spotifyService SpotifyService, appContext util.ApplicationContext) AppTokenProvider {
+	return &appTokenProvider{
+		spotifyService: spotifyService,
+		appContext:     appContext,
+	}
+}
+
+func (a *appTokenProvider) GetAppAccessToken(ctx context.Context) (string, time.Time, error) {
+	a.startRefreshLoop.Do(func() {
+		go a.refreshLoop()
+	})
+
+	token, expiresAt := a.appContext.GetAccessTokenWithExpiry()
+	if len(token) != 0 && time.Now().Add(appTokenRefreshWindow).Before(expiresAt) {
+		return token, expiresAt, nil
+	}
+
+	// the shared refresh keeps running for the other callers when this one gives up
+	refreshed := a.refreshGroup.DoChan("app_token", func() (interface{}, error) {
+		return nil, a.refresh()
+	})
+	select {
+	case result := <-refreshed:
+		if result.Err != nil {
+			return "", time.Time{}, result.Err
+		}
+	case <-ctx.Done():
+		return "", time.Time{}, mapContextError(ctx.Err())
+	}
+	token, expiresAt = a.appContext.GetAccessTokenWithExpiry()
+	return token, expiresAt, nil
+}
+
+// refresh runs detached from any caller's context since its result is shared by
+// every caller waiting on it, and by the background refresh loop
+func (a *appTokenProvider) refresh() error {
+	tag := "APP_TOKEN_PROVIDER_REFRESH"
+	ctx, cancel := context.WithTimeout(context.Background(), appTokenRefreshTimeout)
+	defer cancel()
+	resp, err := a.spotifyService.GetAccessTokenWithClientCredentials(ctx)
+	if err != nil {
+		util.ErrorLog.Println(tag+": could not obtain app access token", err.Error())
+		return err
+	}
+	expiresAt := time.Now().Add(time.Duration(resp.ExpiresIn) * time.Second)
+	a.appContext.SetAccessTokenWithExpiry(resp.AccessToken, expiresAt)
+	return nil
+}
+
+// refreshLoop renews the token ahead of its expiry for as long as the process runs
+func (a *appTokenProvider) refreshLoop() {
+	for {
+		wait := time.Until(a.appContext.GetAccessTokenExpiry().Add(-appTokenRefreshWindow))
+		if wait > 0 {
+			time.Sleep(wait)
+		}
+		_, err, _ := a.refreshGroup.Do("app_token", func() (interface{}, error) {
+			return nil, a.refresh()
+		})
+		// back off when the refresh failed or handed us a token that is already due for renewal
+		if err != nil || time.Until(a.appContext.GetAccessTokenExpiry().Add(-appTokenRefreshWindow)) <= 0 {
+			time.Sleep(appTokenRetryInterval)
+		}
+	}
+}
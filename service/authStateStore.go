@@ -0,0 +1,74 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"mofe64/playlistGen/data/models"
+	"mofe64/playlistGen/util"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// how long a user has to complete the spotify authorization before the state expires
+const authStateTTL = 10 * time.Minute
+
+const authStateKeyPrefix = "oauth_state:"
+
+// AuthStateStore keeps track of the oauth state values we have handed out so the
+// authorization code callback can verify that it was triggered by a request we started
+type AuthStateStore interface {
+	SaveState(ctx context.Context, state string, authState models.AuthState) error
+	ConsumeState(ctx context.Context, state string) (*models.AuthState, error)
+}
+
+type authStateStore struct {
+	redis *redis.Client
+}
+
+func NewAuthStateStore(redisClient *redis.Client) AuthStateStore {
+	return &authStateStore{
+		redis: redisClient,
+	}
+}
+
+func (a *authStateStore) SaveState(ctx context.Context, state string, authState models.AuthState) error {
+	tag := "AUTH_STATE_STORE_SAVE_STATE"
+	jsonValue, err := json.Marshal(authState)
+	if err != nil {
+		util.ErrorLog.Println(tag+": Could not convert auth state to json", err.Error())
+		return err
+	}
+	if err := a.redis.Set(ctx, authStateKeyPrefix+state, string(jsonValue), authStateTTL).Err(); err != nil {
+		util.ErrorLog.Println(tag+": Could not set auth state in redis", err.Error())
+		return err
+	}
+	return nil
+}
+
+// ConsumeState retrieves and deletes the saved state in one step so that a state
+// value can only ever be used once
+func (a *authStateStore) ConsumeState(ctx context.Context, state string) (*models.AuthState, error) {
+	tag := "AUTH_STATE_STORE_CONSUME_STATE"
+	if len(state) == 0 {
+		return nil, util.ApplicationAuthError{
+			Message: "Missing state parameter",
+		}
+	}
+	val, err := a.redis.GetDel(ctx, authStateKeyPrefix+state).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, util.ApplicationAuthError{
+				Message: "Invalid or expired state parameter",
+			}
+		}
+		util.ErrorLog.Println(tag+": Could not retrieve auth state from redis", err.Error())
+		return nil, err
+	}
+	var authState models.AuthState
+	if err := json.Unmarshal([]byte(val), &authState); err != nil {
+		util.ErrorLog.Println(tag+": Could not parse auth state", err.Error())
+		return nil, err
+	}
+	return &authState, nil
+}
@@ -0,0 +1,53 @@
+package service
+
+import (
+	"fmt"
+	"mofe64/playlistGen/util"
+)
+
+// spotify caps both the audio features and the add tracks endpoints at 100 items per request
+const (
+	audioFeaturesBatchSize   = 100
+	playlistItemsBatchSize   = 100
+	audioFeaturesConcurrency = 4
+)
+
+// chunk splits items into consecutive batches of at most size items
+func chunk(items []string, size int) [][]string {
+	batches := [][]string{}
+	for start := 0; start < len(items); start += size {
+		end := start + size
+		if end > len(items) {
+			end = len(items)
+		}
+		batches = append(batches, items[start:end])
+	}
+	return batches
+}
+
+// partialFailure collects the items of each batch according to whether the batch failed
+// and returns an ApplicationPartialFailureError when at least one did, nil otherwise
+func partialFailure(operation string, batches [][]string, errs []error) error {
+	var cause error
+	succeeded := []string{}
+	failed := []string{}
+	for index, batch := range batches {
+		if errs[index] != nil {
+			if cause == nil {
+				cause = errs[index]
+			}
+			failed = append(failed, batch...)
+		} else {
+			succeeded = append(succeeded, batch...)
+		}
+	}
+	if cause == nil {
+		return nil
+	}
+	return util.ApplicationPartialFailureError{
+		Message:   fmt.Sprintf("%s failed for %d of %d items: %s", operation, len(failed), len(failed)+len(succeeded), cause.Error()),
+		Succeeded: succeeded,
+		Failed:    failed,
+		Cause:     cause,
+	}
+}
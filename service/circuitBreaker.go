@@ -0,0 +1,133 @@
+package service
+
+import (
+	"mofe64/playlistGen/util"
+	"sync"
+	"time"
+)
+
+type CircuitState string
+
+const (
+	CircuitClosed   CircuitState = "closed"
+	CircuitOpen     CircuitState = "open"
+	CircuitHalfOpen CircuitState = "half_open"
+)
+
+// endpoint groups sharing a breaker
+const (
+	accountsEndpointGroup = "accounts"
+	webAPIEndpointGroup   = "web_api"
+)
+
+// CircuitBreakerSnapshot is the state of a breaker at a point in time
+type CircuitBreakerSnapshot struct {
+	Name                string       `json:"name"`
+	State               CircuitState `json:"state"`
+	ConsecutiveFailures int          `json:"consecutive_failures"`
+	OpenedAt            *time.Time   `json:"opened_at,omitempty"`
+	LastFailure         string       `json:"last_failure,omitempty"`
+}
+
+// circuitBreaker stops requests to a spotify endpoint group after a run of failures so that
+// callers fail fast instead of piling up waiting on timeouts. Once openTimeout has passed
+// a single probe request is let through, its outcome decides whether the breaker closes
+// again or goes back to open
+type circuitBreaker struct {
+	mu                  sync.Mutex
+	name                string
+	failureThreshold    int
+	openTimeout         time.Duration
+	state               CircuitState
+	consecutiveFailures int
+	openedAt            time.Time
+	probeInFlight       bool
+	lastFailure         string
+}
+
+func newCircuitBreaker(name string, failureThreshold int, openTimeout time.Duration) *circuitBreaker {
+	return &circuitBreaker{
+		name:             name,
+		failureThreshold: failureThreshold,
+		openTimeout:      openTimeout,
+		state:            CircuitClosed,
+	}
+}
+
+// Allow reports whether a request may be sent, returning an ApplicationUnavailableError when not
+func (b *circuitBreaker) Allow() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	switch b.state {
+	case CircuitOpen:
+		if time.Since(b.openedAt) < b.openTimeout {
+			return b.unavailableError()
+		}
+		b.state = CircuitHalfOpen
+		b.probeInFlight = true
+		return nil
+	case CircuitHalfOpen:
+		if b.probeInFlight {
+			return b.unavailableError()
+		}
+		b.probeInFlight = true
+		return nil
+	}
+	return nil
+}
+
+// Record reports the outcome of a request that Allow let through
+func (b *circuitBreaker) Record(failure error) {
+	tag := "SPOTIFY_CIRCUIT_BREAKER"
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probeInFlight = false
+	if failure == nil {
+		if b.state != CircuitClosed {
+			util.InfoLog.Println(tag + ": closing circuit for " + b.name)
+		}
+		b.state = CircuitClosed
+		b.consecutiveFailures = 0
+		return
+	}
+
+	b.consecutiveFailures++
+	b.lastFailure = failure.Error()
+	if b.state == CircuitHalfOpen || b.consecutiveFailures >= b.failureThreshold {
+		if b.state != CircuitOpen {
+			util.ErrorLog.Println(tag+": opening circuit for "+b.name+" after failure", failure.Error())
+		}
+		b.state = CircuitOpen
+		b.openedAt = time.Now()
+	}
+}
+
+// Release hands back a request slot without recording an outcome, used when the caller
+// gave up on the request so it says nothing about spotify's health
+func (b *circuitBreaker) Release() {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.probeInFlight = false
+}
+
+func (b *circuitBreaker) Snapshot() CircuitBreakerSnapshot {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	snapshot := CircuitBreakerSnapshot{
+		Name:                b.name,
+		State:               b.state,
+		ConsecutiveFailures: b.consecutiveFailures,
+		LastFailure:         b.lastFailure,
+	}
+	if b.state != CircuitClosed {
+		openedAt := b.openedAt
+		snapshot.OpenedAt = &openedAt
+	}
+	return snapshot
+}
+
+func (b *circuitBreaker) unavailableError() error {
+	return util.ApplicationUnavailableError{
+		Message: "Spotify " + b.name + " is currently unavailable, please try again later",
+	}
+}
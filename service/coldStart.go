@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"mofe64/playlistGen/data/models"
+	"mofe64/playlistGen/util"
+)
+
+// Where the tracks and artists a profile was built from came from. Users with little
+// listening history fall through them in this order
+const (
+	SeedSourceTopItems        = "top_items"
+	SeedSourceRecentlyPlayed  = "recently_played"
+	SeedSourceSavedTracks     = "saved_tracks"
+	SeedSourceRequestedSeeds  = "requested_seeds"
+	SeedSourceRequestedGenres = "requested_genres"
+	SeedSourceCuratedDefaults = "curated_defaults"
+)
+
+// how many recently played or saved tracks are fetched when top items come up empty
+const coldStartHistoryLimit = 50
+
+// genres used as seeds when we know nothing about the user and they picked none
+var curatedDefaultGenres = []string{"pop", "indie", "hip-hop", "electronic", "rock"}
+
+// seedCandidates are the tracks and artists a profile is built from
+type seedCandidates struct {
+	source  string
+	tracks  []models.Item
+	artists []models.Item
+	genres  []string
+}
+
+// findSeedCandidates picks what to build the profile from. Top items are preferred, then
+// recently played and saved tracks. Without any listening history the seeds the user asked
+// for are used on their own, and failing that a curated set of genres. A fallback source
+// that errors is skipped rather than failing the generation
+func (g *playlistGenerator) findSeedCandidates(ctx context.Context, accessToken string, spec PlaylistSpec, topTracks []models.Item, topArtists []models.Item) seedCandidates {
+	tag := "PLAYLIST_GENERATOR_FIND_SEED_CANDIDATES"
+	if len(topTracks) != 0 {
+		return seedCandidates{source: SeedSourceTopItems, tracks: topTracks, artists: artistsOrFallback(topArtists, topTracks)}
+	}
+
+	recentlyPlayed, err := g.spotify.GetRecentlyPlayed(ctx, accessToken, coldStartHistoryLimit)
+	if err != nil {
+		util.ErrorLog.Println(tag+": could not retrieve recently played tracks", err.Error())
+	}
+	tracks := []models.Item{}
+	for _, played := range recentlyPlayed {
+		tracks = append(tracks, trackToItem(played.Track))
+	}
+	if tracks = uniqueItems(tracks); len(tracks) != 0 {
+		return seedCandidates{source: SeedSourceRecentlyPlayed, tracks: tracks, artists: artistsOrFallback(topArtists, tracks)}
+	}
+
+	savedTracks, err := g.spotify.GetSavedTracks(ctx, accessToken, coldStartHistoryLimit)
+	if err != nil {
+		util.ErrorLog.Println(tag+": could not retrieve saved tracks", err.Error())
+	}
+	for _, saved := range savedTracks {
+		tracks = append(tracks, trackToItem(saved.Track))
+	}
+	if tracks = uniqueItems(tracks); len(tracks) != 0 {
+		return seedCandidates{source: SeedSourceSavedTracks, tracks: tracks, artists: artistsOrFallback(topArtists, tracks)}
+	}
+
+	if len(topArtists) != 0 {
+		return seedCandidates{source: SeedSourceTopItems, artists: topArtists}
+	}
+	if len(spec.SeedTracks) != 0 || len(spec.SeedArtists) != 0 {
+		return seedCandidates{source: SeedSourceRequestedSeeds}
+	}
+	if len(spec.SeedGenres) != 0 {
+		return seedCandidates{source: SeedSourceRequestedGenres}
+	}
+	return seedCandidates{source: SeedSourceCuratedDefaults, genres: curatedDefaultGenres}
+}
+
+func trackToItem(track models.Track) models.Item {
+	return models.Item{
+		Explicit:   track.Explicit,
+		Href:       track.Href,
+		Id:         track.Id,
+		Name:       track.Name,
+		Popularity: track.Popularity,
+		Uri:        track.Uri,
+		Type:       "track",
+		Artists:    track.Artists,
+	}
+}
+
+// artistsOrFallback returns the user's top artists, or the artists of the given tracks
+// when they have none
+func artistsOrFallback(topArtists []models.Item, tracks []models.Item) []models.Item {
+	if len(topArtists) != 0 {
+		return topArtists
+	}
+	artists := []models.Item{}
+	for _, track := range tracks {
+		for _, artist := range track.Artists {
+			artists = append(artists, models.Item{Id: artist.Id, Name: artist.Name, Type: "artist"})
+		}
+	}
+	return uniqueItems(artists)
+}
+
+// uniqueItems drops repeated and id-less items, keeping the first occurrence of each
+func uniqueItems(items []models.Item) []models.Item {
+	seen := map[string]bool{}
+	unique := []models.Item{}
+	for _, item := range items {
+		if len(item.Id) == 0 || seen[item.Id] {
+			continue
+		}
+		seen[item.Id] = true
+		unique = append(unique, item)
+	}
+	return unique
+}
@@ -0,0 +1,83 @@
+package service
+
+import "strconv"
+
+// Stages a playlist generation goes through, in order
+const (
+	StageTopTracks      = "top_tracks"
+	StageTopArtists     = "top_artists"
+	StageSeedSource     = "seed_source"
+	StageAudioFeatures  = "audio_features"
+	StageClusters       = "clusters"
+	StageProfile        = "profile"
+	StageRecommendation = "recommendations"
+	StageCreatePlaylist = "create_playlist"
+	StageAddTracks      = "add_tracks"
+	StageSaveProfile    = "save_profile"
+)
+
+// AnalysisStages gather what any profile is built from, DraftStages are the stages of
+// PlaylistGenerator.Draft and PublishStages those of Publish
+var (
+	AnalysisStages = []string{StageTopTracks, StageTopArtists, StageSeedSource, StageAudioFeatures}
+	DraftStages    = append(append([]string{}, AnalysisStages...), StageProfile, StageRecommendation)
+	PublishStages  = []string{StageCreatePlaylist, StageAddTracks, StageSaveProfile}
+)
+
+// GenerationStages are all the stages of PlaylistGenerator.Generate
+func GenerationStages() []string {
+	return append(append([]string{}, DraftStages...), PublishStages...)
+}
+
+// MixesStages are the stages of PlaylistGenerator.GenerateMixes known before clustering.
+// Each mix then goes through the profile to save_profile stages, reported under the mix's
+// prefix, e.g. mix_1:create_playlist
+func MixesStages() []string {
+	return append(append([]string{}, AnalysisStages...), StageClusters)
+}
+
+// MixStage is the name a stage of the mix at index is reported under
+func MixStage(index int, stage string) string {
+	return "mix_" + strconv.Itoa(index+1) + ":" + stage
+}
+
+// ProgressReporter is told about each stage of a generation as it starts and finishes.
+// StageCompleted receives what the stage produced so callers can surface partial results.
+// The top tracks and top artists stages run concurrently, so implementations must be
+// safe for concurrent use
+type ProgressReporter interface {
+	StageStarted(stage string)
+	StageCompleted(stage string, payload interface{})
+	StageFailed(stage string, err error)
+}
+
+type noopProgressReporter struct{}
+
+func (noopProgressReporter) StageStarted(string)                {}
+func (noopProgressReporter) StageCompleted(string, interface{}) {}
+func (noopProgressReporter) StageFailed(string, error)          {}
+
+func progressOrNoop(progress ProgressReporter) ProgressReporter {
+	if progress == nil {
+		return noopProgressReporter{}
+	}
+	return progress
+}
+
+// mixProgressReporter reports the stages of one mix under the mix's prefix
+type mixProgressReporter struct {
+	index    int
+	progress ProgressReporter
+}
+
+func (r mixProgressReporter) StageStarted(stage string) {
+	r.progress.StageStarted(MixStage(r.index, stage))
+}
+
+func (r mixProgressReporter) StageCompleted(stage string, payload interface{}) {
+	r.progress.StageCompleted(MixStage(r.index, stage), payload)
+}
+
+func (r mixProgressReporter) StageFailed(stage string, err error) {
+	r.progress.StageFailed(MixStage(r.index, stage), err)
+}
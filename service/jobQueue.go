@@ -0,0 +1,503 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"mofe64/playlistGen/util"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// Statuses of a job and of each of its stages
+const (
+	JobStatusQueued    = "queued"
+	JobStatusRunning   = "running"
+	JobStatusSucceeded = "succeeded"
+	JobStatusFailed    = "failed"
+
+	StageStatusPending   = "pending"
+	StageStatusRunning   = "running"
+	StageStatusCompleted = "completed"
+	StageStatusFailed    = "failed"
+)
+
+// Kinds of job, a playlist job generates one playlist while a mixes job generates one per
+// taste cluster in the user's history
+const (
+	JobTypePlaylist = "playlist"
+	JobTypeMixes    = "mixes"
+)
+
+const (
+	jobKeyPrefix      = "playlist_job:"
+	jobQueueKey       = "playlist_jobs:queue"
+	jobProcessingKey  = "playlist_jobs:processing"
+	jobLeaseKeyPrefix = "playlist_job_lease:"
+	// finished jobs can be polled for a day before they are dropped
+	jobTTL = 24 * time.Hour
+	// how long a single job may run, generous compared to a request since nobody is waiting on it
+	jobTimeout = 2 * time.Minute
+	// how long a worker blocks waiting for a job before checking whether it should stop
+	jobPollTimeout = 5 * time.Second
+	// a worker holds a lease on the job it runs, renewing it well before it lapses.
+	// A job whose lease lapsed was left behind by a worker that is gone
+	jobLeaseTTL           = 30 * time.Second
+	jobLeaseRenewInterval = 10 * time.Second
+	// how often the processing list is checked for jobs that lost their worker
+	jobRecoverInterval = jobLeaseTTL
+)
+
+// JobStage is the progress of one generation stage within a job
+type JobStage struct {
+	Name       string     `json:"name"`
+	Status     string     `json:"status"`
+	Error      string     `json:"error,omitempty"`
+	StartedAt  *time.Time `json:"startedAt,omitempty"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+}
+
+// PlaylistJob is a playlist generation running in the background
+type PlaylistJob struct {
+	Id        string                    `json:"id"`
+	UserId    string                    `json:"userId"`
+	Type      string                    `json:"type"`
+	Status    string                    `json:"status"`
+	Stages    []JobStage                `json:"stages"`
+	Spec      PlaylistSpec              `json:"spec"`
+	Result    *PlaylistGenerationResult `json:"result,omitempty"`
+	Mixes     *MixesResult              `json:"mixes,omitempty"`
+	Error     string                    `json:"error,omitempty"`
+	CreatedAt time.Time                 `json:"createdAt"`
+	UpdatedAt time.Time                 `json:"updatedAt"`
+}
+
+// stage returns the job's stage with the given name, adding it if the job has not seen it
+// yet. Mixes jobs only learn the stages of each mix once the user's tracks are clustered
+func (j *PlaylistJob) stage(name string) *JobStage {
+	for index := range j.Stages {
+		if j.Stages[index].Name == name {
+			return &j.Stages[index]
+		}
+	}
+	j.Stages = append(j.Stages, JobStage{Name: name, Status: StageStatusPending})
+	return &j.Stages[len(j.Stages)-1]
+}
+
+// createdPlaylist reports whether the job got as far as creating any playlist
+func (j *PlaylistJob) createdPlaylist() bool {
+	for _, stage := range j.Stages {
+		if stage.Status == StageStatusPending {
+			continue
+		}
+		if stage.Name == StageCreatePlaylist || strings.HasSuffix(stage.Name, ":"+StageCreatePlaylist) {
+			return true
+		}
+	}
+	return false
+}
+
+// JobQueue runs playlist generations in the background. Jobs are queued in a redis list and
+// picked up by a pool of workers, which move them to a processing list and hold a lease on
+// them while they run. Every instance checks the processing list and queues again the jobs
+// whose lease has lapsed, which were left behind by a server that stopped without handing
+// them back, while jobs being run by other live instances are left alone
+type JobQueue interface {
+	Enqueue(ctx context.Context, userId string, jobType string, spec PlaylistSpec) (*PlaylistJob, error)
+	GetJob(ctx context.Context, userId string, jobId string) (*PlaylistJob, error)
+	Start(ctx context.Context, workers int)
+}
+
+type jobQueue struct {
+	redis        *redis.Client
+	generator    PlaylistGenerator
+	tokenManager TokenManager
+	startOnce    sync.Once
+}
+
+func NewJobQueue(redisClient *redis.Client, generator PlaylistGenerator, tokenManager TokenManager) JobQueue {
+	return &jobQueue{
+		redis:        redisClient,
+		generator:    generator,
+		tokenManager: tokenManager,
+	}
+}
+
+func (q *jobQueue) Enqueue(ctx context.Context, userId string, jobType string, spec PlaylistSpec) (*PlaylistJob, error) {
+	tag := "JOB_QUEUE_ENQUEUE"
+	jobId, err := generateTokenId()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	job := PlaylistJob{
+		Id:        jobId,
+		UserId:    userId,
+		Type:      jobType,
+		Status:    JobStatusQueued,
+		Stages:    []JobStage{},
+		Spec:      spec,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+	stages := GenerationStages()
+	if jobType == JobTypeMixes {
+		stages = MixesStages()
+	}
+	for _, stage := range stages {
+		job.Stages = append(job.Stages, JobStage{Name: stage, Status: StageStatusPending})
+	}
+	if err := q.saveJob(ctx, &job); err != nil {
+		return nil, err
+	}
+	if err := q.redis.LPush(ctx, jobQueueKey, job.Id).Err(); err != nil {
+		util.ErrorLog.Println(tag+": Could not queue job", err.Error())
+		return nil, err
+	}
+	return &job, nil
+}
+
+// GetJob returns the job only if it belongs to the user
+func (q *jobQueue) GetJob(ctx context.Context, userId string, jobId string) (*PlaylistJob, error) {
+	job, err := q.loadJob(ctx, jobId)
+	if err != nil {
+		return nil, err
+	}
+	if job.UserId != userId {
+		return nil, util.ApplicationNotFoundError{Message: "No job found with Id " + jobId}
+	}
+	return job, nil
+}
+
+// Start starts the workers and the recovery of jobs abandoned by stopped servers.
+// Workers stop once ctx is cancelled, handing any job they were running back to the queue
+func (q *jobQueue) Start(ctx context.Context, workers int) {
+	q.startOnce.Do(func() {
+		go q.recoverJobs(ctx)
+		for i := 0; i < workers; i++ {
+			go q.work(ctx)
+		}
+	})
+}
+
+// recoverJobs periodically requeues jobs in the processing list that no worker holds a lease on.
+// A worker takes its lease just after moving the job to the processing list, so a job is only
+// requeued once it has been seen without a lease on two checks in a row
+func (q *jobQueue) recoverJobs(ctx context.Context) {
+	unleased := map[string]bool{}
+	ticker := time.NewTicker(jobRecoverInterval)
+	defer ticker.Stop()
+	for {
+		unleased = q.requeueAbandonedJobs(ctx, unleased)
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// requeueAbandonedJobs requeues the jobs without a lease that were also unleased on the last
+// check, and returns the jobs without a lease found on this one
+func (q *jobQueue) requeueAbandonedJobs(ctx context.Context, previouslyUnleased map[string]bool) map[string]bool {
+	tag := "JOB_QUEUE_REQUEUE_ABANDONED_JOBS"
+	unleased := map[string]bool{}
+	jobIds, err := q.redis.LRange(ctx, jobProcessingKey, 0, -1).Result()
+	if err != nil {
+		if ctx.Err() == nil {
+			util.ErrorLog.Println(tag+": Could not read processing list", err.Error())
+		}
+		return previouslyUnleased
+	}
+	for _, jobId := range jobIds {
+		leased, err := q.redis.Exists(ctx, jobLeaseKeyPrefix+jobId).Result()
+		if err != nil {
+			util.ErrorLog.Println(tag+": Could not check lease of job "+jobId, err.Error())
+			continue
+		}
+		if leased > 0 {
+			continue
+		}
+		if !previouslyUnleased[jobId] {
+			unleased[jobId] = true
+			continue
+		}
+		/**
+			Only the instance whose LREM removes the job queues it again,
+			so a job abandoned once is not queued twice by two instances
+		**/
+		removed, err := q.redis.LRem(ctx, jobProcessingKey, 1, jobId).Result()
+		if err != nil {
+			util.ErrorLog.Println(tag+": Could not remove abandoned job "+jobId, err.Error())
+			continue
+		}
+		if removed == 0 {
+			continue
+		}
+		// push to the end the workers pop from, so abandoned jobs run first
+		if err := q.redis.RPush(ctx, jobQueueKey, jobId).Err(); err != nil {
+			util.ErrorLog.Println(tag+": Could not requeue abandoned job "+jobId, err.Error())
+			continue
+		}
+		util.InfoLog.Println(tag+": requeued abandoned job", jobId)
+	}
+	return unleased
+}
+
+// acquireLease takes the lease on a job and keeps renewing it until the returned release
+// is called. It returns a nil release when another worker already holds the lease
+func (q *jobQueue) acquireLease(ctx context.Context, jobId string) (func(), error) {
+	tag := "JOB_QUEUE_ACQUIRE_LEASE"
+	leaseKey := jobLeaseKeyPrefix + jobId
+	acquired, err := q.redis.SetNX(ctx, leaseKey, time.Now().Unix(), jobLeaseTTL).Result()
+	if err != nil {
+		util.ErrorLog.Println(tag+": Could not take lease of job "+jobId, err.Error())
+		return nil, err
+	}
+	if !acquired {
+		return nil, nil
+	}
+	stop := make(chan struct{})
+	stopped := make(chan struct{})
+	go func() {
+		defer close(stopped)
+		ticker := time.NewTicker(jobLeaseRenewInterval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-stop:
+				return
+			case <-ticker.C:
+			}
+			renewed, err := q.redis.Expire(context.Background(), leaseKey, jobLeaseTTL).Result()
+			if err != nil {
+				util.ErrorLog.Println(tag+": Could not renew lease of job "+jobId, err.Error())
+				continue
+			}
+			if !renewed {
+				util.ErrorLog.Println(tag+": lost lease of job", jobId)
+			}
+		}
+	}()
+	release := func() {
+		close(stop)
+		<-stopped
+		if err := q.redis.Del(context.Background(), leaseKey).Err(); err != nil {
+			util.ErrorLog.Println(tag+": Could not release lease of job "+jobId, err.Error())
+		}
+	}
+	return release, nil
+}
+
+func (q *jobQueue) work(ctx context.Context) {
+	tag := "JOB_QUEUE_WORKER"
+	for ctx.Err() == nil {
+		jobId, err := q.redis.BLMove(ctx, jobQueueKey, jobProcessingKey, "RIGHT", "LEFT", jobPollTimeout).Result()
+		if err == redis.Nil {
+			continue
+		}
+		if err != nil {
+			if ctx.Err() != nil {
+				return
+			}
+			util.ErrorLog.Println(tag+": Could not take job from queue", err.Error())
+			sleepContext(ctx, time.Second)
+			continue
+		}
+		release, err := q.acquireLease(ctx, jobId)
+		if err != nil {
+			// the job stays in the processing list and is recovered as it has no lease
+			if ctx.Err() != nil {
+				return
+			}
+			sleepContext(ctx, time.Second)
+			continue
+		}
+		if release == nil {
+			// another worker is already running the job, this is a stale copy of it
+			if err := q.redis.LRem(context.Background(), jobProcessingKey, 1, jobId).Err(); err != nil {
+				util.ErrorLog.Println(tag+": Could not remove leased job from processing list", err.Error())
+			}
+			continue
+		}
+		interrupted := q.run(ctx, jobId)
+		if interrupted {
+			q.handBack(jobId)
+		} else if err := q.redis.LRem(context.Background(), jobProcessingKey, 1, jobId).Err(); err != nil {
+			util.ErrorLog.Println(tag+": Could not remove finished job from processing list", err.Error())
+		}
+		release()
+		if interrupted {
+			return
+		}
+	}
+}
+
+// handBack moves a job interrupted by shutdown from the processing list back to the queue,
+// so another instance can run it without waiting for its lease to lapse
+func (q *jobQueue) handBack(jobId string) {
+	tag := "JOB_QUEUE_HAND_BACK"
+	_, err := q.redis.TxPipelined(context.Background(), func(pipe redis.Pipeliner) error {
+		pipe.LRem(context.Background(), jobProcessingKey, 1, jobId)
+		pipe.RPush(context.Background(), jobQueueKey, jobId)
+		return nil
+	})
+	if err != nil {
+		// the job stays in the processing list and is recovered once its lease lapses
+		util.ErrorLog.Println(tag+": Could not requeue interrupted job "+jobId, err.Error())
+	}
+}
+
+// run processes a job and reports whether it was interrupted by the worker stopping
+func (q *jobQueue) run(ctx context.Context, jobId string) bool {
+	tag := "JOB_QUEUE_RUN"
+	job, err := q.loadJob(ctx, jobId)
+	if err != nil {
+		util.ErrorLog.Println(tag+": Could not load job "+jobId, err.Error())
+		return ctx.Err() != nil
+	}
+	if job.Status == JobStatusSucceeded || job.Status == JobStatusFailed {
+		return false
+	}
+	/**
+		A job interrupted after its playlist was created is not run again,
+		doing so would leave the user with a second copy of the playlist
+	**/
+	if job.createdPlaylist() {
+		q.fail(job, errors.New("Generation was interrupted after the playlist was created, please try again"))
+		return false
+	}
+
+	jobCtx, cancel := context.WithTimeout(ctx, jobTimeout)
+	defer cancel()
+	for index := range job.Stages {
+		job.Stages[index] = JobStage{Name: job.Stages[index].Name, Status: StageStatusPending}
+	}
+	// stages of mixes are added again as the rerun reaches them
+	if job.Type == JobTypeMixes {
+		job.Stages = job.Stages[:len(MixesStages())]
+	}
+	job.Status = JobStatusRunning
+	q.saveJob(jobCtx, job)
+
+	session, err := q.tokenManager.GetValidSession(jobCtx, job.UserId)
+	if err != nil {
+		if ctx.Err() != nil {
+			q.requeue(job)
+			return true
+		}
+		q.fail(job, err)
+		return false
+	}
+	progress := &jobProgressReporter{queue: q, job: job}
+	if job.Type == JobTypeMixes {
+		job.Mixes, err = q.generator.GenerateMixes(jobCtx, job.UserId, session.AccessToken, job.Spec, progress)
+	} else {
+		job.Result, err = q.generator.Generate(jobCtx, job.UserId, session.AccessToken, job.Spec, progress)
+	}
+	// mixes that were made are kept when only some of them failed
+	var partialError util.ApplicationPartialFailureError
+	if err != nil && !errors.As(err, &partialError) {
+		if ctx.Err() != nil {
+			q.requeue(job)
+			return true
+		}
+		q.fail(job, err)
+		return false
+	}
+	job.Status = JobStatusSucceeded
+	if err != nil {
+		job.Error = err.Error()
+	}
+	q.saveJob(context.Background(), job)
+	return false
+}
+
+// requeue marks a job interrupted by shutdown as queued again, the worker hands it back to the queue
+func (q *jobQueue) requeue(job *PlaylistJob) {
+	job.Status = JobStatusQueued
+	q.saveJob(context.Background(), job)
+}
+
+func (q *jobQueue) fail(job *PlaylistJob, err error) {
+	job.Status = JobStatusFailed
+	job.Error = err.Error()
+	q.saveJob(context.Background(), job)
+}
+
+func (q *jobQueue) saveJob(ctx context.Context, job *PlaylistJob) error {
+	tag := "JOB_QUEUE_SAVE_JOB"
+	job.UpdatedAt = time.Now()
+	jsonValue, err := json.Marshal(job)
+	if err != nil {
+		util.ErrorLog.Println(tag+": Could not convert job to json", err.Error())
+		return err
+	}
+	if err := q.redis.Set(ctx, jobKeyPrefix+job.Id, string(jsonValue), jobTTL).Err(); err != nil {
+		util.ErrorLog.Println(tag+": Could not save job in redis", err.Error())
+		return err
+	}
+	return nil
+}
+
+func (q *jobQueue) loadJob(ctx context.Context, jobId string) (*PlaylistJob, error) {
+	tag := "JOB_QUEUE_LOAD_JOB"
+	val, err := q.redis.Get(ctx, jobKeyPrefix+jobId).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, util.ApplicationNotFoundError{Message: "No job found with Id " + jobId}
+		}
+		util.ErrorLog.Println(tag+": Could not retrieve job from redis", err.Error())
+		return nil, err
+	}
+	var job PlaylistJob
+	if err := json.Unmarshal([]byte(val), &job); err != nil {
+		util.ErrorLog.Println(tag+": Could not parse job", err.Error())
+		return nil, err
+	}
+	return &job, nil
+}
+
+// jobProgressReporter records stage progress on the job as the generator reports it
+type jobProgressReporter struct {
+	mutex sync.Mutex
+	queue *jobQueue
+	job   *PlaylistJob
+}
+
+func (r *jobProgressReporter) StageStarted(stage string) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if jobStage := r.job.stage(stage); jobStage != nil {
+		now := time.Now()
+		jobStage.Status = StageStatusRunning
+		jobStage.StartedAt = &now
+		r.queue.saveJob(context.Background(), r.job)
+	}
+}
+
+func (r *jobProgressReporter) StageCompleted(stage string, payload interface{}) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if jobStage := r.job.stage(stage); jobStage != nil {
+		now := time.Now()
+		jobStage.Status = StageStatusCompleted
+		jobStage.FinishedAt = &now
+		r.queue.saveJob(context.Background(), r.job)
+	}
+}
+
+func (r *jobProgressReporter) StageFailed(stage string, err error) {
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+	if jobStage := r.job.stage(stage); jobStage != nil {
+		now := time.Now()
+		jobStage.Status = StageStatusFailed
+		jobStage.Error = err.Error()
+		jobStage.FinishedAt = &now
+		r.queue.saveJob(context.Background(), r.job)
+	}
+}
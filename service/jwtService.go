@@ -0,0 +1,122 @@
+package service
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"mofe64/playlistGen/data/responses"
+	"mofe64/playlistGen/util"
+	"time"
+
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	AccessTokenType  = "access"
+	RefreshTokenType = "refresh"
+
+	accessTokenTTL  = 15 * time.Minute
+	refreshTokenTTL = 7 * 24 * time.Hour
+	tokenIssuer     = "playlist-gen"
+)
+
+// AuthClaims are the claims carried by the tokens we issue, the subject is the user's spotify id
+type AuthClaims struct {
+	TokenType string `json:"token_type"`
+	jwt.RegisteredClaims
+}
+
+// JWTService issues and validates our own signed session tokens
+type JWTService interface {
+	GenerateTokenPair(userId string) (*responses.TokenPairResponse, error)
+	ValidateToken(tokenString string, tokenType string) (*AuthClaims, error)
+}
+
+type jwtService struct {
+	secret []byte
+}
+
+func NewJWTService(secret string) JWTService {
+	return &jwtService{
+		secret: []byte(secret),
+	}
+}
+
+func (j *jwtService) GenerateTokenPair(userId string) (*responses.TokenPairResponse, error) {
+	tag := "JWT_SERVICE_GENERATE_TOKEN_PAIR"
+	now := time.Now()
+	accessToken, err := j.signToken(userId, AccessTokenType, now, accessTokenTTL)
+	if err != nil {
+		util.ErrorLog.Println(tag+": could not sign access token", err.Error())
+		return nil, err
+	}
+	refreshToken, err := j.signToken(userId, RefreshTokenType, now, refreshTokenTTL)
+	if err != nil {
+		util.ErrorLog.Println(tag+": could not sign refresh token", err.Error())
+		return nil, err
+	}
+	return &responses.TokenPairResponse{
+		AccessToken:  accessToken,
+		RefreshToken: refreshToken,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(accessTokenTTL.Seconds()),
+	}, nil
+}
+
+func (j *jwtService) signToken(userId string, tokenType string, issuedAt time.Time, ttl time.Duration) (string, error) {
+	tokenId, err := generateTokenId()
+	if err != nil {
+		return "", err
+	}
+	claims := AuthClaims{
+		TokenType: tokenType,
+		RegisteredClaims: jwt.RegisteredClaims{
+			ID:        tokenId,
+			Issuer:    tokenIssuer,
+			Subject:   userId,
+			IssuedAt:  jwt.NewNumericDate(issuedAt),
+			ExpiresAt: jwt.NewNumericDate(issuedAt.Add(ttl)),
+		},
+	}
+	token := jwt.NewWithClaims(jwt.SigningMethodHS256, claims)
+	return token.SignedString(j.secret)
+}
+
+// ValidateToken checks the token's signature, expiry, issuer and type and
+// returns its claims. Any failure is reported as an ApplicationAuthError
+func (j *jwtService) ValidateToken(tokenString string, tokenType string) (*AuthClaims, error) {
+	var claims AuthClaims
+	_, err := jwt.ParseWithClaims(
+		tokenString,
+		&claims,
+		func(token *jwt.Token) (interface{}, error) {
+			return j.secret, nil
+		},
+		jwt.WithValidMethods([]string{jwt.SigningMethodHS256.Alg()}),
+		jwt.WithIssuer(tokenIssuer),
+	)
+	// every token we issue carries an expiry, one without it was not issued by us
+	if err != nil || claims.ExpiresAt == nil {
+		return nil, util.ApplicationAuthError{
+			Message: "Invalid or expired token",
+		}
+	}
+	if claims.TokenType != tokenType {
+		return nil, util.ApplicationAuthError{
+			Message: "Invalid token type",
+		}
+	}
+	if len(claims.Subject) == 0 || claims.IssuedAt == nil {
+		return nil, util.ApplicationAuthError{
+			Message: "Token has no subject",
+		}
+	}
+	return &claims, nil
+}
+
+func generateTokenId() (string, error) {
+	b := make([]byte, 16)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
@@ -0,0 +1,69 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"mofe64/playlistGen/util"
+	"net/http"
+	"strings"
+)
+
+// Page is one page of a spotify paging object. Cursor based endpoints such as recently
+// played leave Offset and Total out, but still link to the next page
+type Page[T any] struct {
+	Href     string `json:"href"`
+	Items    []T    `json:"items"`
+	Limit    int    `json:"limit"`
+	Next     string `json:"next"`
+	Offset   int    `json:"offset"`
+	Previous string `json:"previous"`
+	Total    int    `json:"total"`
+}
+
+// fetchPage retrieves a single page of a paging object
+func fetchPage[T any](ctx context.Context, s *spotifyService, accessToken string, pageUrl string) (*Page[T], error) {
+	tag := "SPOTIFY_SERVICE_FETCH_PAGE"
+	req, err := http.NewRequestWithContext(ctx, "GET", pageUrl, nil)
+	if err != nil {
+		util.ErrorLog.Println(tag+": Error creating request", err)
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+accessToken)
+	resp, err := s.execute(req)
+	if err != nil {
+		util.ErrorLog.Println(tag+": Error executing request", err)
+		return nil, err
+	}
+	var page Page[T]
+	if err := handleResponse(resp, &page); err != nil {
+		util.ErrorLog.Println(tag+": Spotify responded with an error ", err)
+		return nil, err
+	}
+	return &page, nil
+}
+
+// fetchAll follows the next links of a paging object starting at firstUrl and returns the
+// items of every page, up to maxItems when maxItems is above zero
+func fetchAll[T any](ctx context.Context, s *spotifyService, accessToken string, firstUrl string, maxItems int) ([]T, error) {
+	items := []T{}
+	pageUrl := firstUrl
+	for len(pageUrl) != 0 {
+		// only ever send the user's token to spotify, whatever a next link says
+		if !strings.HasPrefix(pageUrl, s.spotifyBaseWebApi) {
+			return items, errors.New("refusing to follow paging link outside the spotify web api")
+		}
+		page, err := fetchPage[T](ctx, s, accessToken, pageUrl)
+		if err != nil {
+			return items, err
+		}
+		items = append(items, page.Items...)
+		if maxItems > 0 && len(items) >= maxItems {
+			return items[:maxItems], nil
+		}
+		if len(page.Items) == 0 {
+			break
+		}
+		pageUrl = page.Next
+	}
+	return items, nil
+}
@@ -0,0 +1,574 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"mofe64/playlistGen/data/models"
+	"mofe64/playlistGen/data/requests"
+	"mofe64/playlistGen/data/responses"
+	"mofe64/playlistGen/recommendation"
+	"mofe64/playlistGen/util"
+	"strings"
+	"sync"
+)
+
+// defaults the generator falls back to for anything a PlaylistSpec leaves out
+const (
+	DefaultPlaylistName        = "Nubari radio for you"
+	DefaultPlaylistDescription = "A custom playlist built just for you"
+	DefaultTrackCount          = 25
+	DefaultMixCount            = 3
+	defaultSeedTrackCount      = 3
+	defaultSeedArtistCount     = 2
+	// spotify accepts at most 5 seeds across tracks, artists and genres
+	maxRecommendationSeeds = 5
+	// number of top tracks whose audio features are analyzed
+	tracksToAnalyzeCutoff = 49
+)
+
+// PlaylistSpec is everything the generator needs to know about the playlist to build
+type PlaylistSpec struct {
+	Details     models.PlaylistDetails         `json:"details"`
+	TrackCount  int                            `json:"track_count"`
+	SeedTracks  []string                       `json:"seed_tracks"`
+	SeedArtists []string                       `json:"seed_artists"`
+	SeedGenres  []string                       `json:"seed_genres"`
+	Targets     requests.FeatureTargets        `json:"targets"`
+	Blend       TasteBlend                     `json:"blend"`
+	Strategy    string                         `json:"strategy"`
+	MixCount    int                            `json:"mix_count"`
+	Filters     requests.RecommendationFilters `json:"filters"`
+}
+
+// NewPlaylistSpec builds a spec from a generation request, filling in defaults
+func NewPlaylistSpec(request requests.PlaylistGenerationRequest) (PlaylistSpec, error) {
+	spec := PlaylistSpec{
+		Details: models.PlaylistDetails{
+			Name:          request.Name,
+			Description:   request.Description,
+			Public:        request.Visibility == "public",
+			Collaborative: request.Collaborative,
+		},
+		TrackCount:  request.TrackCount,
+		SeedTracks:  request.SeedTracks,
+		SeedArtists: request.SeedArtists,
+		SeedGenres:  request.SeedGenres,
+		Blend:       DefaultTasteBlend,
+		MixCount:    request.MixCount,
+	}
+	if request.Targets != nil {
+		spec.Targets = *request.Targets
+	}
+	if request.Filters != nil {
+		spec.Filters = *request.Filters
+	}
+	if len(request.Blend) != 0 {
+		blend, ok := TasteBlendPreset(request.Blend)
+		if !ok {
+			return spec, util.ApplicationError{Message: "Unknown blend " + request.Blend, Status: 400}
+		}
+		spec.Blend = blend
+	}
+	if len(request.Strategy) != 0 {
+		if _, ok := recommendation.Get(request.Strategy); !ok {
+			return spec, util.ApplicationError{Message: "Unknown strategy " + request.Strategy + ", expected one of " + strings.Join(recommendation.Names(), ", "), Status: 400}
+		}
+		spec.Strategy = request.Strategy
+	}
+	if spec.Details.Public && spec.Details.Collaborative {
+		return spec, util.ApplicationError{Message: "Collaborative playlists cannot be public", Status: 400}
+	}
+	if len(spec.SeedTracks)+len(spec.SeedArtists)+len(spec.SeedGenres) > maxRecommendationSeeds {
+		return spec, util.ApplicationError{Message: "At most 5 seeds can be provided across tracks, artists and genres", Status: 400}
+	}
+	if spec.Filters.MinPopularity != nil && spec.Filters.MaxPopularity != nil && *spec.Filters.MinPopularity > *spec.Filters.MaxPopularity {
+		return spec, util.ApplicationError{Message: "Minimum popularity cannot be above the maximum popularity", Status: 400}
+	}
+	return spec.withDefaults(), nil
+}
+
+// DefaultPlaylistSpec is the spec the legacy create_playlist endpoint generates with
+func DefaultPlaylistSpec(blend TasteBlend) PlaylistSpec {
+	return PlaylistSpec{Blend: blend}.withDefaults()
+}
+
+func (spec PlaylistSpec) withDefaults() PlaylistSpec {
+	if len(spec.Details.Name) == 0 {
+		spec.Details.Name = DefaultPlaylistName
+	}
+	if len(spec.Details.Description) == 0 {
+		spec.Details.Description = DefaultPlaylistDescription
+	}
+	if spec.TrackCount == 0 {
+		spec.TrackCount = DefaultTrackCount
+	}
+	if spec.Blend == (TasteBlend{}) {
+		spec.Blend = DefaultTasteBlend
+	}
+	if len(spec.Strategy) == 0 {
+		spec.Strategy = recommendation.DefaultStrategy
+	}
+	if spec.MixCount == 0 {
+		spec.MixCount = DefaultMixCount
+	}
+	return spec
+}
+
+// PlaylistDraft holds everything worked out for a playlist before it is created on spotify
+type PlaylistDraft struct {
+	Details         models.PlaylistDetails            `json:"details"`
+	SeedSource      string                            `json:"seedSource"`
+	Profile         models.RecommendationProfile      `json:"recommendationConfig"`
+	TopTracks       responses.TopItemsResponse        `json:"topTracks"`
+	TopArtists      responses.TopItemsResponse        `json:"topArtists"`
+	Features        responses.TracksAudioFeatures     `json:"features"`
+	Recommendations responses.RecommendationsResponse `json:"recommendations"`
+	Refinement      *RefinementReport                 `json:"refinement"`
+}
+
+// PlaylistGenerationResult is a draft that has been created on spotify and saved
+type PlaylistGenerationResult struct {
+	PlaylistDraft
+	Playlist   *models.Playlist `json:"playlist"`
+	SnapshotId string           `json:"snapshotId"`
+}
+
+// PlaylistGenerator builds playlists from a user's listening history. Draft works out the
+// recommendation profile and the recommended tracks, Publish creates the playlist on
+// spotify and saves the profile, Generate does both. progress may be nil.
+// Once the playlist has been created a result is always returned, along with the error of
+// adding its tracks or saving its profile if either failed, so callers never lose track of it
+type PlaylistGenerator interface {
+	Draft(ctx context.Context, userId string, accessToken string, spec PlaylistSpec, progress ProgressReporter) (*PlaylistDraft, error)
+	Publish(ctx context.Context, userId string, accessToken string, draft PlaylistDraft, progress ProgressReporter) (*PlaylistGenerationResult, error)
+	Generate(ctx context.Context, userId string, accessToken string, spec PlaylistSpec, progress ProgressReporter) (*PlaylistGenerationResult, error)
+	Regenerate(ctx context.Context, userId string, accessToken string, profile models.RecommendationProfile, mode string, progress ProgressReporter) (*PlaylistGenerationResult, error)
+	GenerateMixes(ctx context.Context, userId string, accessToken string, spec PlaylistSpec, progress ProgressReporter) (*MixesResult, error)
+}
+
+// MixesResult holds one generated playlist per taste cluster found in the user's history
+type MixesResult struct {
+	SeedSource string                     `json:"seedSource"`
+	Mixes      []PlaylistGenerationResult `json:"mixes"`
+}
+
+// Ways a saved profile can be regenerated. Refreshing replaces the tracks of the playlist
+// the profile created, while new creates another playlist from the same profile
+const (
+	RegenerateModeRefresh = "refresh"
+	RegenerateModeNew     = "new"
+)
+
+type playlistGenerator struct {
+	spotify      SpotifyService
+	profileStore ProfileStore
+}
+
+func NewPlaylistGenerator(spotify SpotifyService, profileStore ProfileStore) PlaylistGenerator {
+	return &playlistGenerator{
+		spotify:      spotify,
+		profileStore: profileStore,
+	}
+}
+
+func (g *playlistGenerator) Generate(ctx context.Context, userId string, accessToken string, spec PlaylistSpec, progress ProgressReporter) (*PlaylistGenerationResult, error) {
+	draft, err := g.Draft(ctx, userId, accessToken, spec, progress)
+	if err != nil {
+		return nil, err
+	}
+	return g.Publish(ctx, userId, accessToken, *draft, progress)
+}
+
+func (g *playlistGenerator) Draft(ctx context.Context, userId string, accessToken string, spec PlaylistSpec, progress ProgressReporter) (*PlaylistDraft, error) {
+	progress = progressOrNoop(progress)
+	draft, candidates, err := g.analyzeTaste(ctx, accessToken, spec, progress)
+	if err != nil {
+		return nil, err
+	}
+	if err := g.recommend(ctx, userId, accessToken, spec, draft, candidates, draft.Features.AudioFeatures, progress); err != nil {
+		return nil, err
+	}
+	return draft, nil
+}
+
+// analyzeTaste gathers what the user's profile is built from, their top items, the seed
+// candidates their history allows and the audio features of those candidates' tracks
+func (g *playlistGenerator) analyzeTaste(ctx context.Context, accessToken string, spec PlaylistSpec, progress ProgressReporter) (*PlaylistDraft, seedCandidates, error) {
+	tag := "PLAYLIST_GENERATOR_ANALYZE_TASTE"
+	draft := PlaylistDraft{Details: spec.Details}
+	var operationErrorMutex sync.Mutex
+	var operationError error
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		progress.StageStarted(StageTopTracks)
+		items, err := BlendTopItems(ctx, g.spotify, accessToken, "tracks", spec.Blend)
+		if err != nil {
+			util.ErrorLog.Println(tag+": could not retrieve user top tracks", err.Error())
+			progress.StageFailed(StageTopTracks, err)
+			operationErrorMutex.Lock()
+			operationError = err
+			operationErrorMutex.Unlock()
+			return
+		}
+		draft.TopTracks = responses.TopItemsResponse{Items: items, Total: int16(len(items))}
+		progress.StageCompleted(StageTopTracks, draft.TopTracks)
+	}()
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		progress.StageStarted(StageTopArtists)
+		items, err := BlendTopItems(ctx, g.spotify, accessToken, "artists", spec.Blend)
+		if err != nil {
+			util.ErrorLog.Println(tag+": could not retrieve user top artists", err.Error())
+			progress.StageFailed(StageTopArtists, err)
+			operationErrorMutex.Lock()
+			operationError = err
+			operationErrorMutex.Unlock()
+			return
+		}
+		draft.TopArtists = responses.TopItemsResponse{Items: items, Total: int16(len(items))}
+		progress.StageCompleted(StageTopArtists, draft.TopArtists)
+	}()
+	wg.Wait()
+	if operationError != nil {
+		return nil, seedCandidates{}, operationError
+	}
+
+	progress.StageStarted(StageSeedSource)
+	candidates := g.findSeedCandidates(ctx, accessToken, spec, draft.TopTracks.Items, draft.TopArtists.Items)
+	draft.SeedSource = candidates.source
+	progress.StageCompleted(StageSeedSource, map[string]interface{}{
+		"seedSource": candidates.source,
+		"tracks":     len(candidates.tracks),
+		"artists":    len(candidates.artists),
+	})
+
+	progress.StageStarted(StageAudioFeatures)
+	tracksToAnalyze := []string{}
+	for index, track := range candidates.tracks {
+		if index >= tracksToAnalyzeCutoff {
+			break
+		}
+		tracksToAnalyze = append(tracksToAnalyze, track.Id)
+	}
+	// without any tracks to analyze the profile is built from seeds alone
+	features := &responses.TracksAudioFeatures{AudioFeatures: []responses.Features{}}
+	var err error
+	if len(tracksToAnalyze) != 0 {
+		features, err = g.spotify.GetTracksAudioFeatures(ctx, tracksToAnalyze, accessToken)
+	}
+	if err != nil {
+		// the features we did get are still enough to build a profile from
+		var partialError util.ApplicationPartialFailureError
+		if !errors.As(err, &partialError) || len(features.AudioFeatures) == 0 {
+			util.ErrorLog.Println(tag+": could not get audio features for tracks", err.Error())
+			progress.StageFailed(StageAudioFeatures, err)
+			return nil, seedCandidates{}, err
+		}
+		util.ErrorLog.Println(tag+": could not get audio features for some tracks", err.Error())
+	}
+	draft.Features = *features
+	progress.StageCompleted(StageAudioFeatures, map[string]interface{}{
+		"analyzed": len(draft.Features.AudioFeatures),
+		"features": draft.Features,
+	})
+	return &draft, candidates, nil
+}
+
+// recommend builds the profile for the draft from the given features and asks spotify for
+// the tracks matching it, re-ranked and filtered as the spec asks
+func (g *playlistGenerator) recommend(ctx context.Context, userId string, accessToken string, spec PlaylistSpec, draft *PlaylistDraft, candidates seedCandidates, features []responses.Features, progress ProgressReporter) error {
+	tag := "PLAYLIST_GENERATOR_RECOMMEND"
+	progress.StageStarted(StageProfile)
+	strategy, ok := recommendation.Get(spec.Strategy)
+	if !ok {
+		strategy, _ = recommendation.Get(recommendation.DefaultStrategy)
+	}
+	profile := strategy.BuildProfile(features, candidates.tracks, candidates.artists)
+	applyFeatureTargets(profile, spec.Targets)
+	profile.CreatorId = userId
+	profile.PlaylistName = draft.Details.Name
+	profile.Limit = int16(spec.TrackCount)
+	profile.SeedSource = candidates.source
+	profile.SeedTracks, profile.SeedArtists, profile.SeedGenres = chooseSeeds(spec, candidates)
+	draft.Profile = *profile
+	progress.StageCompleted(StageProfile, draft.Profile)
+
+	progress.StageStarted(StageRecommendation)
+	recommendations, refinement, err := g.refineRecommendations(ctx, accessToken, draft.Profile, spec.Filters, draft.TopTracks.Items)
+	if err != nil {
+		util.ErrorLog.Println(tag+": could not get recommendations", err.Error())
+		progress.StageFailed(StageRecommendation, err)
+		return err
+	}
+	draft.Recommendations = *recommendations
+	draft.Refinement = refinement
+	progress.StageCompleted(StageRecommendation, draft.Recommendations)
+	return nil
+}
+
+func (g *playlistGenerator) Publish(ctx context.Context, userId string, accessToken string, draft PlaylistDraft, progress ProgressReporter) (*PlaylistGenerationResult, error) {
+	tag := "PLAYLIST_GENERATOR_PUBLISH"
+	progress = progressOrNoop(progress)
+	progress.StageStarted(StageCreatePlaylist)
+	createdPlaylist, err := g.spotify.CreatePlaylist(ctx, accessToken, userId, draft.Details)
+	if err != nil {
+		util.ErrorLog.Println(tag+": could not create playlist", err.Error())
+		progress.StageFailed(StageCreatePlaylist, err)
+		return nil, err
+	}
+	progress.StageCompleted(StageCreatePlaylist, createdPlaylist)
+
+	progress.StageStarted(StageAddTracks)
+	uris := []string{}
+	for _, track := range draft.Recommendations.Tracks {
+		uris = append(uris, track.Uri)
+	}
+	snapshotId, addErr := g.spotify.AddTracksToPlaylist(ctx, accessToken, createdPlaylist.Id, uris)
+	var partialError util.ApplicationPartialFailureError
+	switch {
+	case addErr == nil:
+		progress.StageCompleted(StageAddTracks, map[string]interface{}{"snapshotId": snapshotId})
+	case errors.As(addErr, &partialError):
+		util.ErrorLog.Println(tag+": could not add every track to playlist", addErr.Error())
+		progress.StageCompleted(StageAddTracks, map[string]interface{}{
+			"snapshotId": snapshotId,
+			"added":      partialError.Succeeded,
+			"failed":     partialError.Failed,
+		})
+	default:
+		util.ErrorLog.Println(tag+": could not add tracks to playlist", addErr.Error())
+		progress.StageFailed(StageAddTracks, addErr)
+	}
+
+	/**
+		The playlist exists on spotify from here on. Its profile is saved even when not every
+		track was added, so the playlist can be finished by refreshing the profile instead of
+		being created again
+	**/
+	progress.StageStarted(StageSaveProfile)
+	draft.Profile.PlaylistId = createdPlaylist.Id
+	draft.Profile.SnapshotId = snapshotId
+	result := &PlaylistGenerationResult{
+		PlaylistDraft: draft,
+		Playlist:      createdPlaylist,
+		SnapshotId:    snapshotId,
+	}
+	if err := g.profileStore.SaveProfile(ctx, &draft.Profile); err != nil {
+		util.ErrorLog.Println(tag+": could not save profile", err.Error())
+		progress.StageFailed(StageSaveProfile, err)
+		return result, err
+	}
+	progress.StageCompleted(StageSaveProfile, draft.Profile)
+	result.Profile = draft.Profile
+	return result, addErr
+}
+
+// Regenerate asks spotify for fresh recommendations with the seeds and targets saved in the
+// profile. In refresh mode they replace the tracks of the profile's playlist, otherwise a new
+// playlist is created and saved as a new profile. Refreshing is the default when the profile
+// knows its playlist, profiles saved before playlists were tracked can only make a new one
+func (g *playlistGenerator) Regenerate(ctx context.Context, userId string, accessToken string, profile models.RecommendationProfile, mode string, progress ProgressReporter) (*PlaylistGenerationResult, error) {
+	tag := "PLAYLIST_GENERATOR_REGENERATE"
+	progress = progressOrNoop(progress)
+	if len(mode) == 0 {
+		mode = RegenerateModeNew
+		if len(profile.PlaylistId) != 0 {
+			mode = RegenerateModeRefresh
+		}
+	}
+	if mode == RegenerateModeRefresh && len(profile.PlaylistId) == 0 {
+		return nil, util.ApplicationError{Message: "Profile has no playlist to refresh, regenerate it as a new playlist instead", Status: 400}
+	}
+
+	progress.StageStarted(StageRecommendation)
+	// filters are not saved with the profile, so regenerated tracks are only re-ranked
+	recommendations, refinement, err := g.refineRecommendations(ctx, accessToken, profile, requests.RecommendationFilters{}, nil)
+	if err != nil {
+		util.ErrorLog.Println(tag+": could not get recommendations", err.Error())
+		progress.StageFailed(StageRecommendation, err)
+		return nil, err
+	}
+	progress.StageCompleted(StageRecommendation, *recommendations)
+
+	draft := PlaylistDraft{
+		Details: models.PlaylistDetails{
+			Name:        profile.PlaylistName,
+			Description: DefaultPlaylistDescription,
+		},
+		SeedSource:      profile.SeedSource,
+		Profile:         profile,
+		Recommendations: *recommendations,
+		Refinement:      refinement,
+	}
+	if mode == RegenerateModeNew {
+		return g.Publish(ctx, userId, accessToken, draft, progress)
+	}
+
+	progress.StageStarted(StageAddTracks)
+	uris := []string{}
+	for _, track := range recommendations.Tracks {
+		uris = append(uris, track.Uri)
+	}
+	snapshotId, err := g.spotify.ReplacePlaylistTracks(ctx, accessToken, profile.PlaylistId, uris)
+	if err != nil {
+		util.ErrorLog.Println(tag+": could not replace playlist tracks", err.Error())
+		progress.StageFailed(StageAddTracks, err)
+		return nil, err
+	}
+	progress.StageCompleted(StageAddTracks, map[string]interface{}{"snapshotId": snapshotId})
+
+	progress.StageStarted(StageSaveProfile)
+	if err := g.profileStore.UpdateSnapshot(ctx, profile.Id, snapshotId); err != nil {
+		progress.StageFailed(StageSaveProfile, err)
+		return nil, err
+	}
+	draft.Profile.SnapshotId = snapshotId
+	progress.StageCompleted(StageSaveProfile, draft.Profile)
+	return &PlaylistGenerationResult{
+		PlaylistDraft: draft,
+		Playlist: &models.Playlist{
+			Id:         profile.PlaylistId,
+			Name:       profile.PlaylistName,
+			SnapshotId: snapshotId,
+		},
+		SnapshotId: snapshotId,
+	}, nil
+}
+
+// GenerateMixes clusters the user's tracks by their audio features and generates a playlist
+// per cluster, named after what the cluster's tracks have in common. Each mix is built only
+// from its own cluster's tracks. Mixes that fail do not stop the rest, they are reported in
+// an ApplicationPartialFailureError returned along with the mixes that were made
+func (g *playlistGenerator) GenerateMixes(ctx context.Context, userId string, accessToken string, spec PlaylistSpec, progress ProgressReporter) (*MixesResult, error) {
+	tag := "PLAYLIST_GENERATOR_GENERATE_MIXES"
+	progress = progressOrNoop(progress)
+	analysis, candidates, err := g.analyzeTaste(ctx, accessToken, spec, progress)
+	if err != nil {
+		return nil, err
+	}
+
+	progress.StageStarted(StageClusters)
+	clusters := recommendation.ClusterFeatures(analysis.Features.AudioFeatures, spec.MixCount)
+	if len(clusters) == 0 {
+		err := util.ApplicationError{Message: "Not enough listening history to build mixes from", Status: 400}
+		progress.StageFailed(StageClusters, err)
+		return nil, err
+	}
+	clusterSummaries := []map[string]interface{}{}
+	for _, cluster := range clusters {
+		clusterSummaries = append(clusterSummaries, map[string]interface{}{
+			"name":   cluster.Name,
+			"tracks": len(cluster.Features),
+		})
+	}
+	progress.StageCompleted(StageClusters, clusterSummaries)
+
+	tracksById := map[string]models.Item{}
+	for _, track := range candidates.tracks {
+		tracksById[track.Id] = track
+	}
+	result := MixesResult{SeedSource: candidates.source, Mixes: []PlaylistGenerationResult{}}
+	batches := [][]string{}
+	errs := []error{}
+	for index, cluster := range clusters {
+		mixProgress := mixProgressReporter{index: index, progress: progress}
+		clusterCandidates := seedCandidates{source: candidates.source, tracks: []models.Item{}}
+		for _, feature := range cluster.Features {
+			clusterCandidates.tracks = append(clusterCandidates.tracks, tracksById[feature.Id])
+		}
+		clusterCandidates.artists = artistsOrFallback(nil, clusterCandidates.tracks)
+
+		draft := *analysis
+		draft.Details = spec.Details
+		draft.Details.Name = strings.ToUpper(cluster.Name[:1]) + cluster.Name[1:] + " mix"
+		if len(spec.Details.Description) == 0 || spec.Details.Description == DefaultPlaylistDescription {
+			draft.Details.Description = "A " + cluster.Name + " mix built from your listening"
+		}
+		draft.Features = responses.TracksAudioFeatures{AudioFeatures: cluster.Features}
+
+		batches = append(batches, []string{draft.Details.Name})
+		err := g.recommend(ctx, userId, accessToken, spec, &draft, clusterCandidates, cluster.Features, mixProgress)
+		if err == nil {
+			// a mix whose playlist was created is kept even when not all of it went through
+			var published *PlaylistGenerationResult
+			if published, err = g.Publish(ctx, userId, accessToken, draft, mixProgress); published != nil {
+				result.Mixes = append(result.Mixes, *published)
+			}
+		}
+		if err != nil {
+			util.ErrorLog.Println(tag+": could not generate mix "+cluster.Name, err.Error())
+		}
+		errs = append(errs, err)
+	}
+	if len(result.Mixes) == 0 {
+		return nil, errs[0]
+	}
+	if err := partialFailure("generating mixes", batches, errs); err != nil {
+		return &result, err
+	}
+	return &result, nil
+}
+
+// chooseSeeds uses the seeds the spec asks for and fills the remaining seed slots from the
+// candidates, preferring 3 tracks and 2 artists as before, then any candidate genres
+func chooseSeeds(spec PlaylistSpec, candidates seedCandidates) ([]string, []string, []string) {
+	remaining := maxRecommendationSeeds - len(spec.SeedTracks) - len(spec.SeedArtists) - len(spec.SeedGenres)
+
+	seedTracks := append([]string{}, spec.SeedTracks...)
+	if len(spec.SeedTracks) == 0 {
+		for _, track := range candidates.tracks {
+			if len(seedTracks) == defaultSeedTrackCount || remaining == 0 {
+				break
+			}
+			seedTracks = append(seedTracks, track.Id)
+			remaining--
+		}
+	}
+	seedArtists := append([]string{}, spec.SeedArtists...)
+	if len(spec.SeedArtists) == 0 {
+		for _, artist := range candidates.artists {
+			if len(seedArtists) == defaultSeedArtistCount || remaining == 0 {
+				break
+			}
+			seedArtists = append(seedArtists, artist.Id)
+			remaining--
+		}
+	}
+	seedGenres := append([]string{}, spec.SeedGenres...)
+	for _, genre := range candidates.genres {
+		if remaining == 0 {
+			break
+		}
+		seedGenres = append(seedGenres, genre)
+		remaining--
+	}
+	return seedTracks, seedArtists, seedGenres
+}
+
+// applyFeatureTargets replaces the derived targets with any the spec sets explicitly. A derived
+// band the new target falls outside of is dropped so the two don't contradict each other
+func applyFeatureTargets(profile *models.RecommendationProfile, targets requests.FeatureTargets) {
+	overrides := map[*models.AttributeRange]*float32{
+		&profile.Acousticness:     targets.Acousticness,
+		&profile.Danceability:     targets.Danceability,
+		&profile.Energy:           targets.Energy,
+		&profile.Instrumentalness: targets.Instrumentalness,
+		&profile.Liveness:         targets.Liveness,
+		&profile.Tempo:            targets.Tempo,
+		&profile.Valence:          targets.Valence,
+	}
+	for attribute, override := range overrides {
+		if override == nil {
+			continue
+		}
+		target := *override
+		attribute.Target = &target
+		if (attribute.Min != nil && target < *attribute.Min) || (attribute.Max != nil && target > *attribute.Max) {
+			attribute.Min = nil
+			attribute.Max = nil
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"mofe64/playlistGen/util"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// how long a user has to commit a playlist preview before it expires
+const PreviewTTL = 30 * time.Minute
+
+const previewKeyPrefix = "playlist_preview:"
+
+// PreviewStore keeps playlist drafts generated in preview mode so the exact tracks a user
+// was shown can later be turned into a real playlist. Previews are scoped to the user
+// they were generated for
+type PreviewStore interface {
+	SavePreview(ctx context.Context, userId string, token string, draft PlaylistDraft) error
+	ConsumePreview(ctx context.Context, userId string, token string) (*PlaylistDraft, error)
+}
+
+type previewStore struct {
+	redis *redis.Client
+}
+
+func NewPreviewStore(redisClient *redis.Client) PreviewStore {
+	return &previewStore{
+		redis: redisClient,
+	}
+}
+
+func previewKey(userId string, token string) string {
+	return previewKeyPrefix + userId + ":" + token
+}
+
+func (p *previewStore) SavePreview(ctx context.Context, userId string, token string, draft PlaylistDraft) error {
+	tag := "PREVIEW_STORE_SAVE_PREVIEW"
+	jsonValue, err := json.Marshal(draft)
+	if err != nil {
+		util.ErrorLog.Println(tag+": Could not convert preview to json", err.Error())
+		return err
+	}
+	if err := p.redis.Set(ctx, previewKey(userId, token), string(jsonValue), PreviewTTL).Err(); err != nil {
+		util.ErrorLog.Println(tag+": Could not set preview in redis", err.Error())
+		return err
+	}
+	return nil
+}
+
+// ConsumePreview retrieves and deletes the preview in one step so that it can
+// only be committed once
+func (p *previewStore) ConsumePreview(ctx context.Context, userId string, token string) (*PlaylistDraft, error) {
+	tag := "PREVIEW_STORE_CONSUME_PREVIEW"
+	val, err := p.redis.GetDel(ctx, previewKey(userId, token)).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return nil, util.ApplicationNotFoundError{
+				Message: "Preview not found or expired",
+			}
+		}
+		util.ErrorLog.Println(tag+": Could not retrieve preview from redis", err.Error())
+		return nil, err
+	}
+	var draft PlaylistDraft
+	if err := json.Unmarshal([]byte(val), &draft); err != nil {
+		util.ErrorLog.Println(tag+": Could not parse preview", err.Error())
+		return nil, err
+	}
+	return &draft, nil
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"context"
+	"mofe64/playlistGen/data/models"
+	"mofe64/playlistGen/util"
+	"time"
+
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/bson/primitive"
+	"go.mongodb.org/mongo-driver/mongo"
+	"go.mongodb.org/mongo-driver/mongo/options"
+)
+
+// ProfileStore keeps the recommendation profile of every playlist generated for a user
+type ProfileStore interface {
+	ListProfiles(ctx context.Context, userId string, limit int64, offset int64) ([]models.RecommendationProfile, int64, error)
+	GetProfile(ctx context.Context, userId string, profileId string) (*models.RecommendationProfile, error)
+	SaveProfile(ctx context.Context, profile *models.RecommendationProfile) error
+	UpdateSnapshot(ctx context.Context, profileId primitive.ObjectID, snapshotId string) error
+}
+
+type profileStore struct {
+	profileCollection *mongo.Collection
+}
+
+func NewProfileStore(profileCollection *mongo.Collection) ProfileStore {
+	return &profileStore{
+		profileCollection: profileCollection,
+	}
+}
+
+// ListProfiles returns a page of the user's profiles, newest first, and how many they have in total
+func (p *profileStore) ListProfiles(ctx context.Context, userId string, limit int64, offset int64) ([]models.RecommendationProfile, int64, error) {
+	tag := "PROFILE_STORE_LIST_PROFILES"
+	filter := bson.M{"creatorid": userId}
+	total, err := p.profileCollection.CountDocuments(ctx, filter)
+	if err != nil {
+		util.ErrorLog.Println(tag+": DB count err", err.Error())
+		return nil, 0, err
+	}
+	findOptions := options.Find().
+		SetSort(bson.D{{Key: "_id", Value: -1}}).
+		SetSkip(offset).
+		SetLimit(limit)
+	cursor, err := p.profileCollection.Find(ctx, filter, findOptions)
+	if err != nil {
+		util.ErrorLog.Println(tag+": DB retrieve err", err.Error())
+		return nil, 0, err
+	}
+	profiles := []models.RecommendationProfile{}
+	if err := cursor.All(ctx, &profiles); err != nil {
+		util.ErrorLog.Println(tag+": could not decode profiles", err.Error())
+		return nil, 0, err
+	}
+	return profiles, total, nil
+}
+
+// GetProfile returns the profile only if it was created for the user
+func (p *profileStore) GetProfile(ctx context.Context, userId string, profileId string) (*models.RecommendationProfile, error) {
+	tag := "PROFILE_STORE_GET_PROFILE"
+	notFound := util.ApplicationNotFoundError{Message: "No profile found with Id " + profileId}
+	id, err := primitive.ObjectIDFromHex(profileId)
+	if err != nil {
+		return nil, notFound
+	}
+	var profile models.RecommendationProfile
+	err = p.profileCollection.FindOne(ctx, bson.M{"_id": id, "creatorid": userId}).Decode(&profile)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, notFound
+		}
+		util.ErrorLog.Println(tag+": DB retrieve err", err.Error())
+		return nil, err
+	}
+	return &profile, nil
+}
+
+// SaveProfile inserts the profile under a new id
+func (p *profileStore) SaveProfile(ctx context.Context, profile *models.RecommendationProfile) error {
+	tag := "PROFILE_STORE_SAVE_PROFILE"
+	profile.Id = primitive.NewObjectID()
+	profile.CreatedAt = time.Now()
+	profile.UpdatedAt = profile.CreatedAt
+	if _, err := p.profileCollection.InsertOne(ctx, profile); err != nil {
+		util.ErrorLog.Println(tag+": DB Insertion err", err.Error())
+		return err
+	}
+	return nil
+}
+
+// UpdateSnapshot records the snapshot of the profile's playlist after it was refreshed
+func (p *profileStore) UpdateSnapshot(ctx context.Context, profileId primitive.ObjectID, snapshotId string) error {
+	tag := "PROFILE_STORE_UPDATE_SNAPSHOT"
+	update := bson.M{"$set": bson.M{"snapshotid": snapshotId, "updatedat": time.Now()}}
+	if _, err := p.profileCollection.UpdateOne(ctx, bson.M{"_id": profileId}, update); err != nil {
+		util.ErrorLog.Println(tag+": DB Update err", err.Error())
+		return err
+	}
+	return nil
+}
@@ -0,0 +1,106 @@
+package service
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// tokenBucket is a rate limiter shared by every goroutine sending requests through it.
+// Tokens are added at a fixed rate up to the burst size and each request takes one,
+// requests that find the bucket empty wait until their token has been added
+type tokenBucket struct {
+	mu         sync.Mutex
+	rate       float64
+	burst      float64
+	tokens     float64
+	lastRefill time.Time
+}
+
+func newTokenBucket(ratePerSecond float64, burst int) *tokenBucket {
+	return &tokenBucket{
+		rate:       ratePerSecond,
+		burst:      float64(burst),
+		tokens:     float64(burst),
+		lastRefill: time.Now(),
+	}
+}
+
+// Wait blocks until a token is available or ctx is done
+func (t *tokenBucket) Wait(ctx context.Context) error {
+	wait := t.reserve()
+	if wait <= 0 {
+		return nil
+	}
+	timer := time.NewTimer(wait)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		t.cancelReservation()
+		return ctx.Err()
+	}
+}
+
+// reserve takes a token, possibly one that has not been added yet, and returns how long
+// the caller has to wait before that token exists
+func (t *tokenBucket) reserve() time.Duration {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	now := time.Now()
+	t.tokens += now.Sub(t.lastRefill).Seconds() * t.rate
+	if t.tokens > t.burst {
+		t.tokens = t.burst
+	}
+	t.lastRefill = now
+	t.tokens--
+	if t.tokens >= 0 {
+		return 0
+	}
+	return time.Duration(-t.tokens / t.rate * float64(time.Second))
+}
+
+// cancelReservation hands back a token reserved by a caller that gave up waiting
+func (t *tokenBucket) cancelReservation() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.tokens++
+}
+
+// retryBudget caps retries to a share of the requests sent, so that when spotify is
+// struggling we don't multiply the load we put on it. Every request deposits a fraction
+// of a token, every retry withdraws a whole one
+type retryBudget struct {
+	mu        sync.Mutex
+	ratio     float64
+	maxTokens float64
+	tokens    float64
+}
+
+func newRetryBudget(ratio float64, maxTokens float64) *retryBudget {
+	return &retryBudget{
+		ratio:     ratio,
+		maxTokens: maxTokens,
+		tokens:    maxTokens,
+	}
+}
+
+func (r *retryBudget) deposit() {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.tokens += r.ratio
+	if r.tokens > r.maxTokens {
+		r.tokens = r.maxTokens
+	}
+}
+
+func (r *retryBudget) withdraw() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.tokens < 1 {
+		return false
+	}
+	r.tokens--
+	return true
+}
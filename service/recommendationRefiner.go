@@ -0,0 +1,217 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"math"
+	"mofe64/playlistGen/data/models"
+	"mofe64/playlistGen/data/requests"
+	"mofe64/playlistGen/data/responses"
+	"mofe64/playlistGen/util"
+	"sort"
+)
+
+const (
+	// how many more candidates than needed are asked for, so filtering still leaves enough
+	recommendationOverFetchFactor = 3
+	// spotify returns at most 100 recommendations per request
+	maxRecommendationCandidates = 100
+)
+
+// Names of the filters reported in a RefinementReport
+const (
+	FilterExplicit        = "exclude_explicit"
+	FilterTracksPerArtist = "max_tracks_per_artist"
+	FilterTopTracks       = "exclude_top_tracks"
+	FilterMinPopularity   = "min_popularity"
+	FilterMaxPopularity   = "max_popularity"
+)
+
+// how much each attribute counts towards a candidate's distance from the profile, and the
+// span its values are divided by so attributes on different scales are comparable
+var rerankAttributes = map[string]struct {
+	weight float64
+	span   float64
+	value  func(feature responses.Features) float64
+}{
+	"acousticness":     {1.0, 1, func(f responses.Features) float64 { return float64(f.Acousticness) }},
+	"danceability":     {1.2, 1, func(f responses.Features) float64 { return float64(f.Danceability) }},
+	"energy":           {1.5, 1, func(f responses.Features) float64 { return float64(f.Energy) }},
+	"instrumentalness": {1.0, 1, func(f responses.Features) float64 { return float64(f.Instrumentalness) }},
+	"liveness":         {0.5, 1, func(f responses.Features) float64 { return float64(f.Liveness) }},
+	"loudness":         {0.5, 60, func(f responses.Features) float64 { return float64(f.Loudness) }},
+	"speechiness":      {0.8, 1, func(f responses.Features) float64 { return float64(f.Speechiness) }},
+	"tempo":            {1.0, 250, func(f responses.Features) float64 { return float64(f.Tempo) }},
+	"valence":          {1.2, 1, func(f responses.Features) float64 { return float64(f.Valence) }},
+}
+
+// FilterReport describes what one filter did to the candidates
+type FilterReport struct {
+	Name    string      `json:"name"`
+	Enabled bool        `json:"enabled"`
+	Value   interface{} `json:"value,omitempty"`
+	Removed int         `json:"removed"`
+}
+
+// RefinementReport describes how spotify's recommendations were narrowed down to the
+// tracks that were kept
+type RefinementReport struct {
+	Candidates int            `json:"candidates"`
+	Reranked   bool           `json:"reranked"`
+	Filters    []FilterReport `json:"filters"`
+	Returned   int            `json:"returned"`
+}
+
+// refineRecommendations over-fetches recommendations for the profile, re-ranks them by their
+// weighted distance to the profile's targets and applies the filters, keeping the best
+// profile.Limit tracks. Candidates whose features can't be fetched rank after the rest
+func (g *playlistGenerator) refineRecommendations(ctx context.Context, accessToken string, profile models.RecommendationProfile, filters requests.RecommendationFilters, topTracks []models.Item) (*responses.RecommendationsResponse, *RefinementReport, error) {
+	tag := "PLAYLIST_GENERATOR_REFINE_RECOMMENDATIONS"
+	wanted := int(profile.Limit)
+	candidateProfile := profile
+	candidateProfile.Limit = int16(math.Min(float64(wanted*recommendationOverFetchFactor), maxRecommendationCandidates))
+	recommendations, err := g.spotify.GetRecommendations(ctx, accessToken, candidateProfile)
+	if err != nil {
+		return nil, nil, err
+	}
+	report := RefinementReport{Candidates: len(recommendations.Tracks), Filters: []FilterReport{}}
+
+	trackIds := []string{}
+	for _, track := range recommendations.Tracks {
+		trackIds = append(trackIds, track.Id)
+	}
+	featuresById := map[string]responses.Features{}
+	if len(trackIds) != 0 {
+		features, err := g.spotify.GetTracksAudioFeatures(ctx, trackIds, accessToken)
+		var partialError util.ApplicationPartialFailureError
+		if err != nil && !errors.As(err, &partialError) {
+			// ranking is a refinement, spotify's own order is still a sensible fallback
+			util.ErrorLog.Println(tag+": could not get audio features for candidates", err.Error())
+		} else {
+			for _, feature := range features.AudioFeatures {
+				if len(feature.Id) != 0 {
+					featuresById[feature.Id] = feature
+				}
+			}
+		}
+	}
+	tracks := recommendations.Tracks
+	if len(featuresById) != 0 {
+		tracks = rerank(tracks, featuresById, profile)
+		report.Reranked = true
+	}
+
+	tracks = applyFilters(tracks, filters, topTracks, &report)
+	if len(tracks) > wanted {
+		tracks = tracks[:wanted]
+	}
+	report.Returned = len(tracks)
+	recommendations.Tracks = tracks
+	return recommendations, &report, nil
+}
+
+// rerank orders the tracks by how close their features are to the profile's targets.
+// Each attribute counts by its weight, plus a full point for falling outside the profile's band
+func rerank(tracks []models.Track, featuresById map[string]responses.Features, profile models.RecommendationProfile) []models.Track {
+	attributes := profile.Attributes()
+	distances := map[string]float64{}
+	for _, track := range tracks {
+		feature, ok := featuresById[track.Id]
+		if !ok {
+			distances[track.Id] = math.Inf(1)
+			continue
+		}
+		var distance float64
+		for name, attribute := range rerankAttributes {
+			attributeRange := attributes[name]
+			value := attribute.value(feature)
+			if attributeRange.Target != nil {
+				distance += attribute.weight * math.Abs(value-float64(*attributeRange.Target)) / attribute.span
+			}
+			if (attributeRange.Min != nil && value < float64(*attributeRange.Min)) || (attributeRange.Max != nil && value > float64(*attributeRange.Max)) {
+				distance += attribute.weight
+			}
+		}
+		distances[track.Id] = distance
+	}
+	ranked := append([]models.Track{}, tracks...)
+	sort.SliceStable(ranked, func(i, j int) bool {
+		return distances[ranked[i].Id] < distances[ranked[j].Id]
+	})
+	return ranked
+}
+
+// applyFilters drops the tracks the filters exclude in ranked order, so the per artist cap
+// keeps each artist's best matches, and records what each filter removed
+func applyFilters(tracks []models.Track, filters requests.RecommendationFilters, topTracks []models.Item, report *RefinementReport) []models.Track {
+	topTrackIds := map[string]bool{}
+	for _, track := range topTracks {
+		topTrackIds[track.Id] = true
+	}
+	type filter struct {
+		report  FilterReport
+		exclude func(track models.Track) bool
+	}
+	artistCounts := map[string]int{}
+	filterList := []*filter{
+		{
+			report:  FilterReport{Name: FilterExplicit, Enabled: filters.ExcludeExplicit},
+			exclude: func(track models.Track) bool { return track.Explicit },
+		},
+		{
+			report:  FilterReport{Name: FilterTopTracks, Enabled: filters.ExcludeTopTracks},
+			exclude: func(track models.Track) bool { return topTrackIds[track.Id] },
+		},
+		{
+			report: FilterReport{Name: FilterMinPopularity, Enabled: filters.MinPopularity != nil},
+			exclude: func(track models.Track) bool {
+				return int(track.Popularity) < *filters.MinPopularity
+			},
+		},
+		{
+			report: FilterReport{Name: FilterMaxPopularity, Enabled: filters.MaxPopularity != nil},
+			exclude: func(track models.Track) bool {
+				return int(track.Popularity) > *filters.MaxPopularity
+			},
+		},
+		// runs last so tracks other filters drop don't use up an artist's allowance
+		{
+			report: FilterReport{Name: FilterTracksPerArtist, Enabled: filters.MaxTracksPerArtist > 0},
+			exclude: func(track models.Track) bool {
+				if len(track.Artists) == 0 {
+					return false
+				}
+				artistCounts[track.Artists[0].Id]++
+				return artistCounts[track.Artists[0].Id] > filters.MaxTracksPerArtist
+			},
+		},
+	}
+	if filters.MinPopularity != nil {
+		filterList[2].report.Value = *filters.MinPopularity
+	}
+	if filters.MaxPopularity != nil {
+		filterList[3].report.Value = *filters.MaxPopularity
+	}
+	if filters.MaxTracksPerArtist > 0 {
+		filterList[4].report.Value = filters.MaxTracksPerArtist
+	}
+
+	kept := []models.Track{}
+	for _, track := range tracks {
+		excluded := false
+		for _, f := range filterList {
+			if f.report.Enabled && f.exclude(track) {
+				f.report.Removed++
+				excluded = true
+				break
+			}
+		}
+		if !excluded {
+			kept = append(kept, track)
+		}
+	}
+	for _, f := range filterList {
+		report.Filters = append(report.Filters, f.report)
+	}
+	return kept
+}
@@ -0,0 +1,105 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"mofe64/playlistGen/data/responses"
+	"mofe64/playlistGen/util"
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// spotifyErrorBody covers both error formats spotify uses. The web api nests a status
+// and message object under "error", the accounts service sends an error code string
+// under "error" with the message in "error_description"
+type spotifyErrorBody struct {
+	Error            json.RawMessage `json:"error"`
+	ErrorDescription string          `json:"error_description"`
+}
+
+// handleResponse turns any non 2xx response into a typed application error carrying
+// spotify's message. Successful responses are decoded into out unless out is nil
+func handleResponse(resp *spotifyResponse, out interface{}) error {
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return mapErrorResponse(resp)
+	}
+	if out == nil || len(resp.Body) == 0 {
+		return nil
+	}
+	return json.Unmarshal(resp.Body, out)
+}
+
+func mapErrorResponse(resp *spotifyResponse) error {
+	message, code := parseErrorBody(resp)
+	status := resp.StatusCode
+
+	switch {
+	case status == http.StatusBadRequest && len(code) != 0:
+		// the accounts service answers rejected grants and clients with a 400
+		return util.ApplicationAuthError{Message: message, Status: status, Code: code}
+	case status == http.StatusUnauthorized:
+		return util.ApplicationAuthError{Message: message, Status: status, Code: code}
+	case status == http.StatusForbidden:
+		return util.ApplicationForbiddenError{Message: message, Status: status, Code: code}
+	case status == http.StatusNotFound:
+		return util.ApplicationNotFoundError{Message: message, Status: status, Code: code}
+	case status == http.StatusTooManyRequests:
+		return util.ApplicationRateLimitError{
+			Message:    message,
+			Status:     status,
+			Code:       code,
+			Retryable:  true,
+			RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After")),
+		}
+	case status >= 500:
+		return util.ApplicationUpstreamError{
+			Message:   message,
+			Status:    status,
+			Code:      code,
+			Retryable: status != http.StatusNotImplemented,
+		}
+	}
+	return util.ApplicationError{Message: message, Status: status, Code: code}
+}
+
+func parseErrorBody(resp *spotifyResponse) (string, string) {
+	fallback := "Spotify responded with status " + strconv.Itoa(resp.StatusCode)
+	var body spotifyErrorBody
+	if err := json.Unmarshal(resp.Body, &body); err != nil || len(body.Error) == 0 {
+		return fallback, ""
+	}
+
+	if bytes.HasPrefix(bytes.TrimSpace(body.Error), []byte("{")) {
+		var operationErr responses.ErrorResponse
+		if err := json.Unmarshal(body.Error, &operationErr); err != nil || len(operationErr.Message) == 0 {
+			return fallback, ""
+		}
+		return operationErr.Message, ""
+	}
+
+	var code string
+	if err := json.Unmarshal(body.Error, &code); err != nil {
+		return fallback, ""
+	}
+	if len(body.ErrorDescription) == 0 {
+		return code, code
+	}
+	return body.ErrorDescription, code
+}
+
+// parseRetryAfter reads a Retry-After header given either in seconds or as an http date
+func parseRetryAfter(value string) time.Duration {
+	if len(value) == 0 {
+		return 0
+	}
+	if seconds, err := strconv.Atoi(value); err == nil && seconds > 0 {
+		return time.Duration(seconds) * time.Second
+	}
+	if date, err := http.ParseTime(value); err == nil {
+		if wait := time.Until(date); wait > 0 {
+			return wait
+		}
+	}
+	return 0
+}
@@ -0,0 +1,177 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"math/rand"
+	"mofe64/playlistGen/util"
+	"net"
+	"net/http"
+	"syscall"
+	"time"
+)
+
+// RetryPolicy controls how requests to spotify are retried
+type RetryPolicy struct {
+	// retries attempted after the first request fails, zero disables retrying
+	MaxRetries int
+	// backoff before the first retry, doubled on every retry after that
+	BaseDelay time.Duration
+	// upper bound on the backoff between two attempts
+	MaxDelay time.Duration
+	// a 429 asking us to wait longer than this is returned instead of retried
+	MaxRetryAfter time.Duration
+	// share of requests that may be retried, across all requests sent
+	BudgetRatio float64
+	// retries that can be spent in a burst before the budget runs dry
+	BudgetBurst float64
+}
+
+var defaultRetryPolicy = RetryPolicy{
+	MaxRetries:    3,
+	BaseDelay:     200 * time.Millisecond,
+	MaxDelay:      5 * time.Second,
+	MaxRetryAfter: 10 * time.Second,
+	BudgetRatio:   0.2,
+	BudgetBurst:   10,
+}
+
+// retryTransport wraps the transport spotify requests are sent through. Every attempt
+// waits on the shared rate limiter, 429s are retried after the Retry-After spotify asks
+// for, and 5xx responses and network errors are retried with jittered exponential backoff.
+// Requests that aren't idempotent, like creating a playlist or adding tracks to one, may
+// already have been applied when they fail that way, so they are only retried on a 429 or
+// when the request never left us
+type retryTransport struct {
+	next    http.RoundTripper
+	policy  RetryPolicy
+	limiter *tokenBucket
+	budget  *retryBudget
+}
+
+func newRetryTransport(next http.RoundTripper, policy RetryPolicy, limiter *tokenBucket) *retryTransport {
+	return &retryTransport{
+		next:    next,
+		policy:  policy,
+		limiter: limiter,
+		budget:  newRetryBudget(policy.BudgetRatio, policy.BudgetBurst),
+	}
+}
+
+func (t *retryTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	tag := "SPOTIFY_RETRY_TRANSPORT"
+	ctx := req.Context()
+	t.budget.deposit()
+
+	for attempt := 0; ; attempt++ {
+		if err := t.limiter.Wait(ctx); err != nil {
+			return nil, err
+		}
+
+		attemptReq := req
+		if attempt > 0 && req.Body != nil {
+			// the body of the previous attempt has been consumed, get a fresh copy
+			if req.GetBody == nil {
+				return nil, errors.New("request body cannot be replayed for retry")
+			}
+			body, err := req.GetBody()
+			if err != nil {
+				return nil, err
+			}
+			attemptReq = req.Clone(ctx)
+			attemptReq.Body = body
+		}
+
+		resp, err := t.next.RoundTrip(attemptReq)
+		delay, retryable := t.retryDelay(ctx, req, resp, err, attempt)
+		if !retryable || attempt >= t.policy.MaxRetries || !t.budget.withdraw() {
+			return resp, err
+		}
+
+		if resp != nil {
+			util.InfoLog.Println(tag+": retrying", req.Method, req.URL.Path, "after status", resp.StatusCode, "in", delay)
+			// drain the body so the connection can be reused
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+		} else {
+			util.InfoLog.Println(tag+": retrying", req.Method, req.URL.Path, "after error", err, "in", delay)
+		}
+
+		if err := sleepContext(ctx, delay); err != nil {
+			return nil, err
+		}
+	}
+}
+
+// retryDelay decides whether an attempt should be retried and how long to wait first
+func (t *retryTransport) retryDelay(ctx context.Context, req *http.Request, resp *http.Response, err error, attempt int) (time.Duration, bool) {
+	if err != nil {
+		// our own deadline or cancellation, retrying won't help
+		if ctx.Err() != nil {
+			return 0, false
+		}
+		if !isIdempotent(req.Method) && !notSent(err) {
+			return 0, false
+		}
+		return t.backoff(attempt), true
+	}
+
+	switch resp.StatusCode {
+	case http.StatusTooManyRequests:
+		retryAfter := parseRetryAfter(resp.Header.Get("Retry-After"))
+		if retryAfter == 0 {
+			return t.backoff(attempt), true
+		}
+		if retryAfter > t.policy.MaxRetryAfter {
+			return 0, false
+		}
+		return retryAfter, true
+	case http.StatusInternalServerError, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+		if !isIdempotent(req.Method) {
+			return 0, false
+		}
+		return t.backoff(attempt), true
+	}
+	return 0, false
+}
+
+// isIdempotent reports whether sending the request twice has the same effect as sending it once
+func isIdempotent(method string) bool {
+	switch method {
+	case http.MethodGet, http.MethodHead, http.MethodOptions, http.MethodPut, http.MethodDelete:
+		return true
+	}
+	return false
+}
+
+// notSent reports whether err shows the request failed before it reached spotify,
+// so retrying it can't apply it twice
+func notSent(err error) bool {
+	var opErr *net.OpError
+	if errors.As(err, &opErr) && opErr.Op == "dial" {
+		return true
+	}
+	var dnsErr *net.DNSError
+	return errors.As(err, &dnsErr) || errors.Is(err, syscall.ECONNREFUSED)
+}
+
+// backoff returns a random delay up to the exponential backoff for the attempt ("full jitter")
+func (t *retryTransport) backoff(attempt int) time.Duration {
+	delay := t.policy.BaseDelay << attempt
+	if delay <= 0 || delay > t.policy.MaxDelay {
+		delay = t.policy.MaxDelay
+	}
+	return time.Duration(rand.Int63n(int64(delay) + 1))
+}
+
+func sleepContext(ctx context.Context, delay time.Duration) error {
+	timer := time.NewTimer(delay)
+	defer timer.Stop()
+	select {
+	case <-timer.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
@@ -0,0 +1,333 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+var testRetryPolicy = RetryPolicy{
+	MaxRetries:    3,
+	BaseDelay:     time.Millisecond,
+	MaxDelay:      5 * time.Millisecond,
+	MaxRetryAfter: 2 * time.Second,
+	BudgetRatio:   0.2,
+	BudgetBurst:   10,
+}
+
+// scriptedServer answers each request with the next status in the script, repeating the
+// last one once the script runs out, and records the bodies it was sent
+type scriptedServer struct {
+	mu         sync.Mutex
+	statuses   []int
+	retryAfter string
+	bodies     []string
+}
+
+func (s *scriptedServer) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	body, _ := io.ReadAll(r.Body)
+	s.bodies = append(s.bodies, string(body))
+	status := s.statuses[len(s.statuses)-1]
+	if len(s.bodies) <= len(s.statuses) {
+		status = s.statuses[len(s.bodies)-1]
+	}
+	if status == http.StatusTooManyRequests && len(s.retryAfter) != 0 {
+		w.Header().Set("Retry-After", s.retryAfter)
+	}
+	w.WriteHeader(status)
+}
+
+func (s *scriptedServer) attempts() int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return len(s.bodies)
+}
+
+func TestRetryTransport(t *testing.T) {
+	tests := []struct {
+		name         string
+		method       string
+		body         string
+		statuses     []int
+		retryAfter   string
+		policy       RetryPolicy
+		wantStatus   int
+		wantAttempts int
+		minElapsed   time.Duration
+	}{
+		{
+			name:         "success is not retried",
+			method:       http.MethodGet,
+			statuses:     []int{200},
+			wantStatus:   200,
+			wantAttempts: 1,
+		},
+		{
+			name:         "429 waits for retry-after",
+			method:       http.MethodGet,
+			statuses:     []int{429, 200},
+			retryAfter:   "1",
+			wantStatus:   200,
+			wantAttempts: 2,
+			minElapsed:   time.Second,
+		},
+		{
+			name:         "429 asking for too long a wait is returned",
+			method:       http.MethodGet,
+			statuses:     []int{429, 200},
+			retryAfter:   "60",
+			wantStatus:   429,
+			wantAttempts: 1,
+		},
+		{
+			name:         "429 without retry-after backs off",
+			method:       http.MethodGet,
+			statuses:     []int{429, 200},
+			wantStatus:   200,
+			wantAttempts: 2,
+		},
+		{
+			name:         "503 is retried",
+			method:       http.MethodGet,
+			statuses:     []int{503, 503, 200},
+			wantStatus:   200,
+			wantAttempts: 3,
+		},
+		{
+			name:         "retries stop at max retries",
+			method:       http.MethodGet,
+			statuses:     []int{503},
+			wantStatus:   503,
+			wantAttempts: 4,
+		},
+		{
+			name:         "4xx is not retried",
+			method:       http.MethodGet,
+			statuses:     []int{404, 200},
+			wantStatus:   404,
+			wantAttempts: 1,
+		},
+		{
+			name:         "post is not retried on 5xx",
+			method:       http.MethodPost,
+			body:         `{"name":"mix"}`,
+			statuses:     []int{502, 201},
+			wantStatus:   502,
+			wantAttempts: 1,
+		},
+		{
+			name:         "post is retried on 429 with its body replayed",
+			method:       http.MethodPost,
+			body:         `{"name":"mix"}`,
+			statuses:     []int{429, 201},
+			wantStatus:   201,
+			wantAttempts: 2,
+		},
+		{
+			name:         "put is retried on 5xx with its body replayed",
+			method:       http.MethodPut,
+			body:         `{"uris":["spotify:track:1"]}`,
+			statuses:     []int{500, 201},
+			wantStatus:   201,
+			wantAttempts: 2,
+		},
+		{
+			name:         "an empty budget stops retries",
+			method:       http.MethodGet,
+			statuses:     []int{503, 200},
+			policy:       RetryPolicy{MaxRetries: 3, BaseDelay: time.Millisecond, MaxDelay: time.Millisecond, BudgetRatio: 0.2, BudgetBurst: 0},
+			wantStatus:   503,
+			wantAttempts: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			script := &scriptedServer{statuses: tt.statuses, retryAfter: tt.retryAfter}
+			server := httptest.NewServer(script)
+			defer server.Close()
+			policy := tt.policy
+			if policy == (RetryPolicy{}) {
+				policy = testRetryPolicy
+			}
+			client := &http.Client{Transport: newRetryTransport(http.DefaultTransport, policy, newTokenBucket(1000, 1000))}
+
+			req, err := http.NewRequest(tt.method, server.URL, strings.NewReader(tt.body))
+			if err != nil {
+				t.Fatal(err)
+			}
+			start := time.Now()
+			resp, err := client.Do(req)
+			if err != nil {
+				t.Fatalf("request failed: %v", err)
+			}
+			resp.Body.Close()
+			elapsed := time.Since(start)
+
+			if resp.StatusCode != tt.wantStatus {
+				t.Errorf("status = %d, want %d", resp.StatusCode, tt.wantStatus)
+			}
+			if got := script.attempts(); got != tt.wantAttempts {
+				t.Errorf("attempts = %d, want %d", got, tt.wantAttempts)
+			}
+			if elapsed < tt.minElapsed {
+				t.Errorf("returned after %v, want at least %v", elapsed, tt.minElapsed)
+			}
+			for i, body := range script.bodies {
+				if body != tt.body {
+					t.Errorf("attempt %d body = %q, want %q", i+1, body, tt.body)
+				}
+			}
+		})
+	}
+}
+
+func TestRetryTransportNetworkErrors(t *testing.T) {
+	// a listener that is closed straight away leaves an address nothing answers on
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	url := "http://" + listener.Addr().String()
+	listener.Close()
+
+	tests := []struct {
+		name   string
+		method string
+	}{
+		{name: "get", method: http.MethodGet},
+		{name: "post that was never sent", method: http.MethodPost},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			attempts := 0
+			counting := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+				attempts++
+				return http.DefaultTransport.RoundTrip(req)
+			})
+			client := &http.Client{Transport: newRetryTransport(counting, testRetryPolicy, newTokenBucket(1000, 1000))}
+			req, _ := http.NewRequest(tt.method, url, strings.NewReader("{}"))
+			if _, err := client.Do(req); err == nil {
+				t.Fatal("request to a closed port succeeded")
+			}
+			if attempts != testRetryPolicy.MaxRetries+1 {
+				t.Errorf("attempts = %d, want %d", attempts, testRetryPolicy.MaxRetries+1)
+			}
+		})
+	}
+}
+
+func TestRetryTransportDoesNotRetrySentPost(t *testing.T) {
+	attempts := 0
+	// the connection dropping after the request went out may mean spotify applied it
+	failing := roundTripFunc(func(req *http.Request) (*http.Response, error) {
+		attempts++
+		return nil, io.ErrUnexpectedEOF
+	})
+	client := &http.Client{Transport: newRetryTransport(failing, testRetryPolicy, newTokenBucket(1000, 1000))}
+	req, _ := http.NewRequest(http.MethodPost, "http://spotify.test/v1/users/1/playlists", strings.NewReader("{}"))
+	if _, err := client.Do(req); !errors.Is(err, io.ErrUnexpectedEOF) {
+		t.Fatalf("err = %v, want unexpected EOF", err)
+	}
+	if attempts != 1 {
+		t.Errorf("attempts = %d, want 1", attempts)
+	}
+}
+
+func TestRetryTransportBackoffCap(t *testing.T) {
+	transport := newRetryTransport(http.DefaultTransport, RetryPolicy{BaseDelay: 100 * time.Millisecond, MaxDelay: time.Second}, newTokenBucket(1, 1))
+	for attempt := 0; attempt < 70; attempt++ {
+		ceiling := 100 * time.Millisecond << attempt
+		if attempt >= 4 {
+			ceiling = time.Second
+		}
+		for i := 0; i < 20; i++ {
+			if delay := transport.backoff(attempt); delay < 0 || delay > ceiling {
+				t.Fatalf("backoff(%d) = %v, want between 0 and %v", attempt, delay, ceiling)
+			}
+		}
+	}
+}
+
+func TestParseRetryAfter(t *testing.T) {
+	tests := []struct {
+		name  string
+		value string
+		min   time.Duration
+		max   time.Duration
+	}{
+		{name: "empty", value: "", min: 0, max: 0},
+		{name: "seconds", value: "3", min: 3 * time.Second, max: 3 * time.Second},
+		{name: "zero seconds", value: "0", min: 0, max: 0},
+		{name: "negative seconds", value: "-5", min: 0, max: 0},
+		{name: "garbage", value: "soon", min: 0, max: 0},
+		{name: "http date", value: time.Now().Add(10 * time.Second).UTC().Format(http.TimeFormat), min: 8 * time.Second, max: 10 * time.Second},
+		{name: "http date in the past", value: time.Now().Add(-time.Minute).UTC().Format(http.TimeFormat), min: 0, max: 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := parseRetryAfter(tt.value); got < tt.min || got > tt.max {
+				t.Errorf("parseRetryAfter(%q) = %v, want between %v and %v", tt.value, got, tt.min, tt.max)
+			}
+		})
+	}
+}
+
+func TestRetryBudget(t *testing.T) {
+	budget := newRetryBudget(0.5, 2)
+	if !budget.withdraw() || !budget.withdraw() {
+		t.Fatal("could not withdraw the initial burst")
+	}
+	if budget.withdraw() {
+		t.Fatal("withdrew from an empty budget")
+	}
+	budget.deposit()
+	if budget.withdraw() {
+		t.Fatal("withdrew a whole retry after depositing half of one")
+	}
+	budget.deposit()
+	if !budget.withdraw() {
+		t.Fatal("could not withdraw after depositing a whole retry")
+	}
+	for i := 0; i < 10; i++ {
+		budget.deposit()
+	}
+	if !budget.withdraw() || !budget.withdraw() || budget.withdraw() {
+		t.Fatal("budget grew past its burst")
+	}
+}
+
+func TestTokenBucket(t *testing.T) {
+	bucket := newTokenBucket(20, 2)
+	ctx := context.Background()
+	start := time.Now()
+	for i := 0; i < 4; i++ {
+		if err := bucket.Wait(ctx); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// the burst goes out at once, the two after it wait 50ms each
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("4 requests at 20/s with a burst of 2 took %v, want at least 100ms", elapsed)
+	}
+
+	cancelled, cancel := context.WithCancel(ctx)
+	cancel()
+	if err := bucket.Wait(cancelled); !errors.Is(err, context.Canceled) {
+		t.Errorf("Wait on a cancelled context = %v, want context.Canceled", err)
+	}
+}
+
+type roundTripFunc func(req *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(req *http.Request) (*http.Response, error) {
+	return f(req)
+}
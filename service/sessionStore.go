@@ -0,0 +1,184 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"mofe64/playlistGen/data/models"
+	"mofe64/playlistGen/util"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+	"go.mongodb.org/mongo-driver/bson"
+	"go.mongodb.org/mongo-driver/mongo"
+)
+
+// SessionStore persists a user's spotify session. Redis is used as a cache
+// in front of the users collection in mongo, which remains the source of truth.
+// Tokens are encrypted before they are written to either and decrypted on the way out
+type SessionStore interface {
+	GetSession(ctx context.Context, userId string) (*models.Session, error)
+	SaveSession(ctx context.Context, userId string, session models.Session) error
+	DeleteSession(ctx context.Context, userId string) error
+	RotateEncryptionKeys(ctx context.Context) (int, error)
+}
+
+// cached sessions expire so tokens of users who stop using the app don't stay in redis forever
+const sessionCacheTTL = 24 * time.Hour
+
+type sessionStore struct {
+	redis          *redis.Client
+	userCollection *mongo.Collection
+	cipher         TokenCipher
+}
+
+func NewSessionStore(redisClient *redis.Client, userCollection *mongo.Collection, cipher TokenCipher) SessionStore {
+	return &sessionStore{
+		redis:          redisClient,
+		userCollection: userCollection,
+		cipher:         cipher,
+	}
+}
+
+func (s *sessionStore) GetSession(ctx context.Context, userId string) (*models.Session, error) {
+	tag := "SESSION_STORE_GET_SESSION"
+	var session models.Session
+	val, err := s.redis.Get(ctx, userId).Result()
+	if err == nil {
+		if err := json.Unmarshal([]byte(val), &session); err != nil {
+			util.ErrorLog.Println(tag+": could not parse cached session", err)
+		} else if opened, err := s.cipher.Open(session); err != nil {
+			util.ErrorLog.Println(tag+": could not decrypt cached session", err)
+		} else {
+			return &opened, nil
+		}
+	} else if err != redis.Nil {
+		util.ErrorLog.Println(tag+": could not retrieve from redis", err.Error())
+	}
+
+	// cache miss, fall back to the user's saved auth details
+	var user models.User
+	err = s.userCollection.FindOne(ctx, bson.M{"id": userId}).Decode(&user)
+	if err != nil {
+		if err == mongo.ErrNoDocuments {
+			return nil, util.ApplicationNotFoundError{
+				Message: "No user found with Id " + userId,
+			}
+		}
+		util.ErrorLog.Println(tag+": DB retrieve err", err.Error())
+		return nil, err
+	}
+	opened, err := s.cipher.Open(user.Auth)
+	if err != nil {
+		util.ErrorLog.Println(tag+": could not decrypt session", err.Error())
+		return nil, err
+	}
+	// sessions saved before encryption was introduced are only ever cached encrypted
+	sealed, err := s.cipher.Seal(user.Auth)
+	if err == nil {
+		s.cacheSession(ctx, userId, sealed)
+	}
+	return &opened, nil
+}
+
+func (s *sessionStore) SaveSession(ctx context.Context, userId string, session models.Session) error {
+	tag := "SESSION_STORE_SAVE_SESSION"
+	sealed, err := s.cipher.Seal(session)
+	if err != nil {
+		util.ErrorLog.Println(tag+": could not encrypt session", err.Error())
+		return err
+	}
+	filter := bson.M{"id": userId}
+	update := bson.M{"$set": bson.M{"auth": sealed}}
+	_, err = s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		util.ErrorLog.Println(tag+": DB Update err", err.Error())
+		return err
+	}
+	s.cacheSession(ctx, userId, sealed)
+	return nil
+}
+
+// DeleteSession removes the user's spotify tokens from both the cache and the user's record
+func (s *sessionStore) DeleteSession(ctx context.Context, userId string) error {
+	tag := "SESSION_STORE_DELETE_SESSION"
+	if err := s.redis.Del(ctx, userId).Err(); err != nil {
+		util.ErrorLog.Println(tag+": Could not delete session from redis", err.Error())
+		return err
+	}
+	filter := bson.M{"id": userId}
+	update := bson.M{"$unset": bson.M{"auth": ""}}
+	_, err := s.userCollection.UpdateOne(ctx, filter, update)
+	if err != nil {
+		util.ErrorLog.Println(tag+": DB Update err", err.Error())
+		return err
+	}
+	return nil
+}
+
+// RotateEncryptionKeys re-wraps every stored session that is not yet protected by the
+// active key, encrypting sessions that were stored in plaintext along the way. Sessions
+// stay readable throughout since old keys remain configured until rotation is complete.
+// It returns the number of sessions that were rotated
+func (s *sessionStore) RotateEncryptionKeys(ctx context.Context) (int, error) {
+	tag := "SESSION_STORE_ROTATE_ENCRYPTION_KEYS"
+	filter := bson.M{
+		"auth.accesstoken": bson.M{"$exists": true, "$ne": ""},
+		"auth.keyid":       bson.M{"$ne": s.cipher.ActiveKeyId()},
+	}
+	cursor, err := s.userCollection.Find(ctx, filter)
+	if err != nil {
+		util.ErrorLog.Println(tag+": DB retrieve err", err.Error())
+		return 0, err
+	}
+	defer cursor.Close(ctx)
+
+	rotated := 0
+	for cursor.Next(ctx) {
+		var user models.User
+		if err := cursor.Decode(&user); err != nil {
+			util.ErrorLog.Println(tag+": could not decode user", err.Error())
+			continue
+		}
+		session, err := s.cipher.Rotate(user.Auth)
+		if err != nil {
+			util.ErrorLog.Println(tag+": could not rotate session for user "+user.Id, err.Error())
+			continue
+		}
+		/**
+			Only replace the session if it has not changed since we read it,
+			a token refresh that happened in the meantime already wrote it with the active key
+		**/
+		updateFilter := bson.M{"id": user.Id, "auth.accesstoken": user.Auth.AccessToken}
+		update := bson.M{"$set": bson.M{"auth": session}}
+		result, err := s.userCollection.UpdateOne(ctx, updateFilter, update)
+		if err != nil {
+			util.ErrorLog.Println(tag+": DB Update err", err.Error())
+			continue
+		}
+		if result.ModifiedCount > 0 {
+			rotated++
+			// drop the cached copy, it is re-cached under the active key on next read
+			s.redis.Del(ctx, user.Id)
+		}
+	}
+	if err := cursor.Err(); err != nil {
+		util.ErrorLog.Println(tag+": cursor err", err.Error())
+		return rotated, err
+	}
+	return rotated, nil
+}
+
+// To avoid having to go to the db everytime to retrieve user's auth details,
+// we store the auth details in redis as a json string mapped to the user's id.
+// Failing to cache is not fatal since mongo still holds the session
+func (s *sessionStore) cacheSession(ctx context.Context, userId string, session models.Session) {
+	tag := "SESSION_STORE_CACHE_SESSION"
+	jsonValue, err := json.Marshal(session)
+	if err != nil {
+		util.ErrorLog.Println(tag+": Could not convert spotify token details to json", err.Error())
+		return
+	}
+	if err := s.redis.Set(ctx, userId, string(jsonValue), sessionCacheTTL).Err(); err != nil {
+		util.ErrorLog.Println(tag+": Could not set session in redis", err.Error())
+	}
+}
@@ -2,47 +2,191 @@ package service
 
 import (
 	"bytes"
+	"context"
 	"encoding/base64"
 	"encoding/json"
 	"errors"
 	"fmt"
 	"io"
-	"mofe64/playlistGen/config"
 	"mofe64/playlistGen/data/models"
 	"mofe64/playlistGen/data/responses"
 	"mofe64/playlistGen/util"
+	"net"
 	"net/http"
 	"net/url"
 	"strconv"
 	"strings"
+	"sync"
+	"time"
 )
 
 type SpotifyService interface {
-	GetAccessTokenWithClientCredentials() (*responses.AccessTokenResponse, error)
-	GetAccessTokenWithAuthCode(authCode string) (*responses.AccessTokenResponse, error)
-	GetUserProfile(accessToken string) (*responses.SpotifyUserProfile, error)
-	GetUserTopItems(accessToken string, entityType string) (*responses.TopItemsResponse, error)
-	GetTracksAudioFeatures(trackIds []string, accessToken string) (*responses.TracksAudioFeatures, error)
-	GetRecommendations(accessToken string, config models.RecommendationProfile) (*responses.RecommendationsResponse, error)
-	CreatePlaylist(accessToken string, userId string, name string, desc string) (*models.Playlist, error)
-	AddTracksToPlaylist(accessToken string, playlistId string, trackUris []string) (string, error)
+	GetAccessTokenWithClientCredentials(ctx context.Context) (*responses.AccessTokenResponse, error)
+	GetAccessTokenWithAuthCode(ctx context.Context, authCode string, codeVerifier string) (*responses.AccessTokenResponse, error)
+	RefreshAccessToken(ctx context.Context, refreshToken string) (*responses.AccessTokenResponse, error)
+	GetUserProfile(ctx context.Context, accessToken string) (*responses.SpotifyUserProfile, error)
+	GetUserTopItems(ctx context.Context, accessToken string, entityType string, options TopItemsOptions) (*responses.TopItemsResponse, error)
+	GetAllUserTopItems(ctx context.Context, accessToken string, entityType string, options TopItemsOptions, maxItems int) ([]models.Item, error)
+	GetPlaylistItems(ctx context.Context, accessToken string, playlistId string, maxItems int) ([]models.PlaylistTrack, error)
+	GetSavedTracks(ctx context.Context, accessToken string, maxItems int) ([]models.SavedTrack, error)
+	GetRecentlyPlayed(ctx context.Context, accessToken string, maxItems int) ([]models.PlayHistory, error)
+	GetTracksAudioFeatures(ctx context.Context, trackIds []string, accessToken string) (*responses.TracksAudioFeatures, error)
+	GetRecommendations(ctx context.Context, accessToken string, config models.RecommendationProfile) (*responses.RecommendationsResponse, error)
+	CreatePlaylist(ctx context.Context, accessToken string, userId string, details models.PlaylistDetails) (*models.Playlist, error)
+	AddTracksToPlaylist(ctx context.Context, accessToken string, playlistId string, trackUris []string) (string, error)
+	ReplacePlaylistTracks(ctx context.Context, accessToken string, playlistId string, trackUris []string) (string, error)
+	Search(ctx context.Context, accessToken string, query string, types []string, limit int, offset int) (*responses.SearchResponse, error)
+	GetArtist(ctx context.Context, accessToken string, artistId string) (*models.Item, error)
+	CircuitBreakers() []CircuitBreakerSnapshot
 }
 
 type spotifyService struct {
+	clientId           string
+	clientSecret       string
 	spotifyBaseAuthUrl string
 	spotifyRedirectUri string
 	spotifyBaseWebApi  string
+	httpClient         *http.Client
+	userAgent          string
+	requestTimeout     time.Duration
+	retryPolicy        RetryPolicy
+	rateLimit          float64
+	rateLimitBurst     int
+	breakerThreshold   int
+	breakerOpenTimeout time.Duration
+	breakers           map[string]*circuitBreaker
 }
 
-func NewSpotifyService(redirectUri string) SpotifyService {
-	return &spotifyService{
-		spotifyBaseAuthUrl: "https://accounts.spotify.com",
+// NewSpotifyService creates a SpotifyService talking to spotify's public urls.
+// Options set the client credentials, can point it at other base urls, swap out the http
+// client or transport, and set the user agent, per request timeout, retry policy and rate limit
+func NewSpotifyService(redirectUri string, opts ...SpotifyServiceOption) SpotifyService {
+	s := &spotifyService{
+		spotifyBaseAuthUrl: defaultSpotifyAuthBaseURL,
 		spotifyRedirectUri: redirectUri,
-		spotifyBaseWebApi:  "https://api.spotify.com/v1",
+		spotifyBaseWebApi:  defaultSpotifyAPIBaseURL,
+		httpClient:         &http.Client{},
+		userAgent:          defaultUserAgent,
+		requestTimeout:     defaultRequestTimeout,
+		retryPolicy:        defaultRetryPolicy,
+		rateLimit:          defaultRateLimit,
+		rateLimitBurst:     defaultRateLimitBurst,
+		breakerThreshold:   defaultBreakerThreshold,
+		breakerOpenTimeout: defaultBreakerOpenTimeout,
 	}
+	for _, opt := range opts {
+		opt(s)
+	}
+
+	/**
+		Whichever client or transport was configured, requests go through the retry transport
+		so every endpoint gets the same rate limiting and retry behaviour
+	**/
+	transport := s.httpClient.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	client := *s.httpClient
+	client.Transport = newRetryTransport(transport, s.retryPolicy, newTokenBucket(s.rateLimit, s.rateLimitBurst))
+	s.httpClient = &client
+	s.breakers = map[string]*circuitBreaker{
+		accountsEndpointGroup: newCircuitBreaker(accountsEndpointGroup, s.breakerThreshold, s.breakerOpenTimeout),
+		webAPIEndpointGroup:   newCircuitBreaker(webAPIEndpointGroup, s.breakerThreshold, s.breakerOpenTimeout),
+	}
+	return s
+}
+
+// CircuitBreakers returns the state of the circuit breaker of every spotify endpoint group
+func (s *spotifyService) CircuitBreakers() []CircuitBreakerSnapshot {
+	return []CircuitBreakerSnapshot{
+		s.breakers[accountsEndpointGroup].Snapshot(),
+		s.breakers[webAPIEndpointGroup].Snapshot(),
+	}
+}
+
+func (s *spotifyService) breakerFor(req *http.Request) *circuitBreaker {
+	if strings.HasPrefix(req.URL.String(), s.spotifyBaseAuthUrl) {
+		return s.breakers[accountsEndpointGroup]
+	}
+	return s.breakers[webAPIEndpointGroup]
+}
+
+// recordOutcome tells the breaker how a request went, requests cancelled by
+// the caller are not held against spotify
+func (s *spotifyService) recordOutcome(breaker *circuitBreaker, err error) {
+	if errors.Is(err, context.Canceled) {
+		breaker.Release()
+		return
+	}
+	breaker.Record(err)
+}
+
+// mapContextError reports requests that ran past their deadline as timeouts, so callers
+// can tell them apart from spotify failing. Cancellations are returned unchanged
+func mapContextError(err error) error {
+	var netErr net.Error
+	if errors.Is(err, context.DeadlineExceeded) || (errors.As(err, &netErr) && netErr.Timeout()) {
+		return util.ApplicationTimeoutError{
+			Message: "Request to spotify timed out",
+		}
+	}
+	return err
 }
 
-func (s *spotifyService) GetAccessTokenWithClientCredentials() (*responses.AccessTokenResponse, error) {
+// spotifyResponse is a fully read response from spotify
+type spotifyResponse struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+}
+
+// execute sends the request through the service's http client and reads the whole body,
+// the per request timeout covers both sending the request and reading the response
+func (s *spotifyService) execute(req *http.Request) (*spotifyResponse, error) {
+	ctx := req.Context()
+	if s.requestTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, s.requestTimeout)
+		defer cancel()
+	}
+	req = req.WithContext(ctx)
+	if len(s.userAgent) != 0 {
+		req.Header.Set("User-Agent", s.userAgent)
+	}
+
+	/**
+		Fail fast while spotify is known to be down. The breaker sees the outcome after
+		retries, so it only counts requests that failed for good
+	**/
+	breaker := s.breakerFor(req)
+	if err := breaker.Allow(); err != nil {
+		return nil, err
+	}
+
+	resp, err := s.httpClient.Do(req)
+	if err != nil {
+		s.recordOutcome(breaker, err)
+		return nil, mapContextError(err)
+	}
+	defer resp.Body.Close()
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		s.recordOutcome(breaker, err)
+		return nil, mapContextError(err)
+	}
+	if resp.StatusCode >= 500 {
+		s.recordOutcome(breaker, errors.New(resp.Status))
+	} else {
+		s.recordOutcome(breaker, nil)
+	}
+	return &spotifyResponse{
+		StatusCode: resp.StatusCode,
+		Header:     resp.Header,
+		Body:       body,
+	}, nil
+}
+
+func (s *spotifyService) GetAccessTokenWithClientCredentials(ctx context.Context) (*responses.AccessTokenResponse, error) {
 	tag := "SPOTIFY_SERVICE_GET_ACCESS_TOKEN_CLIENT_CRED"
 	/**
 		Since we are sending formData to the spotify api as application/x-www-form-urlencoded format, we use
@@ -62,8 +206,8 @@ func (s *spotifyService) GetAccessTokenWithClientCredentials() (*responses.Acces
 	**/
 	payload := strings.NewReader(formData.Encode())
 
-	clientId := config.EnvSpotifyClientId()
-	clientSecret := config.EnvSpotifyClientSecret()
+	clientId := s.clientId
+	clientSecret := s.clientSecret
 	authString := clientId + ":" + clientSecret
 
 	/**
@@ -76,9 +220,8 @@ func (s *spotifyService) GetAccessTokenWithClientCredentials() (*responses.Acces
 	encodedAuthString := base64.StdEncoding.EncodeToString([]byte(authString))
 
 	authHeader := "Basic " + encodedAuthString
-	client := &http.Client{}
 	url := s.spotifyBaseAuthUrl + "/api/token"
-	req, err := http.NewRequest("POST", url, payload)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, payload)
 	if err != nil {
 		util.ErrorLog.Println(tag+": Error creating request", err)
 		return nil, err
@@ -86,132 +229,160 @@ func (s *spotifyService) GetAccessTokenWithClientCredentials() (*responses.Acces
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Authorization", authHeader)
 
-	resp, err := client.Do(req)
+	resp, err := s.execute(req)
 	if err != nil {
 		util.ErrorLog.Println(tag+": Error executing request", err)
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		util.ErrorLog.Println(tag+": Error decoding response body", err)
-		return nil, err
-	}
-
-	if resp.StatusCode == 400 {
-		var spotifyErrorRes responses.SpotifyAuthErrorReponse
-		err = json.Unmarshal(body, &spotifyErrorRes)
-		if err != nil {
-			util.ErrorLog.Println(tag+": Error unmarshalling res body ", err)
-			return nil, err
-		}
-		var authError = util.ApplicationAuthError{
-			Message: spotifyErrorRes.ErrorDescription,
-		}
-		return nil, authError
-	}
 
 	var accessTokenResponse responses.AccessTokenResponse
-	err = json.Unmarshal(body, &accessTokenResponse)
-	if err != nil {
-		util.ErrorLog.Println(tag+": Error unmarshalling res body ", err)
+	if err := handleResponse(resp, &accessTokenResponse); err != nil {
+		util.ErrorLog.Println(tag+": Spotify responded with an error ", err)
 		return nil, err
 	}
 	return &accessTokenResponse, nil
 
 }
 
-func (s *spotifyService) GetUserProfile(accesstoken string) (*responses.SpotifyUserProfile, error) {
+func (s *spotifyService) GetUserProfile(ctx context.Context, accesstoken string) (*responses.SpotifyUserProfile, error) {
 	tag := "SPOTIFY_SERVICE_GET_USER_PROFILE"
 
 	url := s.spotifyBaseWebApi + "/me"
 	authHeader := "Bearer " + accesstoken
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", url, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
 	if err != nil {
 		util.ErrorLog.Println("Error creating request", err)
 		return nil, err
 	}
 	req.Header.Set("Authorization", authHeader)
-	resp, err := client.Do(req)
+	resp, err := s.execute(req)
 	if err != nil {
 		util.ErrorLog.Println("Error executing request", err)
 		return nil, err
 	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		util.ErrorLog.Println("Error decoding response body", err)
+	var userProfile responses.SpotifyUserProfile
+	if err := handleResponse(resp, &userProfile); err != nil {
+		util.ErrorLog.Println(tag+": Spotify responded with an error ", err)
 		return nil, err
 	}
 
-	if resp.StatusCode == 401 {
-		var operationErr responses.SpotifyOperationErrorResponse
-		err = json.Unmarshal(body, &operationErr)
-		if err != nil {
-			util.ErrorLog.Println(tag+": Error unmarshalling res body ", err)
-			return nil, err
-		}
-		var authError = util.ApplicationAuthError{
-			Message: operationErr.Error.Message,
-		}
-		return nil, authError
-	}
+	return &userProfile, nil
 
-	if resp.StatusCode == 403 {
-		var operationErr responses.SpotifyOperationErrorResponse
-		err = json.Unmarshal(body, &operationErr)
-		if err != nil {
-			util.ErrorLog.Println(tag+": Error unmarshalling res body ", err)
-			return nil, err
+}
+
+// AddTracksToPlaylist adds the tracks to the top of the playlist in batches of at most 100.
+// Batches are sent one after the other so the tracks keep their order in the playlist.
+// When some batches fail the snapshot of the last successful batch is returned along with
+// an ApplicationPartialFailureError listing which uris were and were not added
+func (s *spotifyService) AddTracksToPlaylist(ctx context.Context, accessToken string, playlistId string, trackUris []string) (string, error) {
+	var tag = "SPOTIFY_SERVICE_ADD_TRACKS_TO_PLAYLIST"
+	util.InfoLog.Println(tag+": uris are --> ", trackUris)
+	batches := chunk(trackUris, playlistItemsBatchSize)
+	errs := make([]error, len(batches))
+	snapshotId := ""
+	position := 0
+	for index, batch := range batches {
+		if err := ctx.Err(); err != nil {
+			errs[index] = mapContextError(err)
+			continue
 		}
-		var applicationError = util.ApplicationError{
-			Message: operationErr.Error.Message,
+		batchSnapshotId, err := s.addTracksBatch(ctx, accessToken, playlistId, batch, position)
+		if err != nil {
+			errs[index] = err
+			continue
 		}
-		return nil, applicationError
-
+		snapshotId = batchSnapshotId
+		position += len(batch)
+	}
+	if err := partialFailure("adding tracks to playlist", batches, errs); err != nil {
+		util.ErrorLog.Println(tag+": ", err)
+		return snapshotId, err
 	}
+	return snapshotId, nil
+}
 
-	if resp.StatusCode == 429 {
-		var operationErr responses.SpotifyOperationErrorResponse
-		err = json.Unmarshal(body, &operationErr)
-		if err != nil {
-			util.ErrorLog.Println(tag+": Error unmarshalling res body ", err)
-			return nil, err
+// ReplacePlaylistTracks replaces every track in the playlist with trackUris. Spotify replaces
+// at most 100 tracks per request, so the first batch replaces the playlist's contents and
+// the rest are appended after it. Failures are reported as in AddTracksToPlaylist
+func (s *spotifyService) ReplacePlaylistTracks(ctx context.Context, accessToken string, playlistId string, trackUris []string) (string, error) {
+	var tag = "SPOTIFY_SERVICE_REPLACE_PLAYLIST_TRACKS"
+	batches := chunk(trackUris, playlistItemsBatchSize)
+	if len(batches) == 0 {
+		// an empty replace clears the playlist
+		batches = [][]string{{}}
+	}
+	errs := make([]error, len(batches))
+	snapshotId, err := s.replaceTracksBatch(ctx, accessToken, playlistId, batches[0])
+	if err != nil {
+		// the old tracks are still in place, appending the rest would mix them in
+		util.ErrorLog.Println(tag+": could not replace playlist tracks", err)
+		return "", err
+	}
+	position := len(batches[0])
+	for index, batch := range batches[1:] {
+		if err := ctx.Err(); err != nil {
+			errs[index+1] = mapContextError(err)
+			continue
 		}
-		var rateLimitError = util.ApplicationRateLimitError{
-			Message: operationErr.Error.Message,
+		batchSnapshotId, err := s.addTracksBatch(ctx, accessToken, playlistId, batch, position)
+		if err != nil {
+			errs[index+1] = err
+			continue
 		}
-		return nil, rateLimitError
+		snapshotId = batchSnapshotId
+		position += len(batch)
+	}
+	if err := partialFailure("replacing playlist tracks", batches, errs); err != nil {
+		util.ErrorLog.Println(tag+": ", err)
+		return snapshotId, err
 	}
+	return snapshotId, nil
+}
 
-	var userProfile responses.SpotifyUserProfile
-	err = json.Unmarshal(body, &userProfile)
+func (s *spotifyService) replaceTracksBatch(ctx context.Context, accessToken string, playlistId string, trackUris []string) (string, error) {
+	var tag = "SPOTIFY_SERVICE_REPLACE_TRACKS_BATCH"
+	reqUrl := s.spotifyBaseWebApi + "/playlists/" + playlistId + "/tracks"
+	reqBody, err := json.Marshal(map[string]interface{}{"uris": trackUris})
 	if err != nil {
-		util.ErrorLog.Println("Error unmarshalling res body ", err)
-		return nil, err
+		util.ErrorLog.Println(tag+": Error marshalling req body  ", err)
+		return "", err
 	}
+	req, err := http.NewRequestWithContext(ctx, "PUT", reqUrl, bytes.NewBuffer(reqBody))
+	if err != nil {
+		util.ErrorLog.Println(tag+": Error creating req  ", err)
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", "Bearer "+accessToken)
 
-	return &userProfile, nil
+	resp, err := s.execute(req)
+	if err != nil {
+		util.ErrorLog.Println(tag+": Error executing request", err)
+		return "", err
+	}
 
+	var snapshot responses.SnapshotResponse
+	if err := handleResponse(resp, &snapshot); err != nil {
+		util.ErrorLog.Println(tag+": Spotify responded with an error ", err)
+		return "", err
+	}
+	return snapshot.SnapshotId, nil
 }
 
-func (s *spotifyService) AddTracksToPlaylist(accessToken string, playlistId string, trackUris []string) (string, error) {
-	var tag = "SPOTIFY_SERVICE_ADD_TRACKS_TO_pLAYLIST"
+func (s *spotifyService) addTracksBatch(ctx context.Context, accessToken string, playlistId string, trackUris []string, position int) (string, error) {
+	var tag = "SPOTIFY_SERVICE_ADD_TRACKS_BATCH"
 	reqUrl := s.spotifyBaseWebApi + "/playlists/" + playlistId + "/tracks"
 	authHeader := "Bearer " + accessToken
-	util.InfoLog.Println(tag+": uris are --> ", trackUris)
 	payload := map[string]interface{}{
 		"uris":     trackUris,
-		"position": 0,
+		"position": position,
 	}
 	reqBody, err := json.Marshal(payload)
 	if err != nil {
 		util.ErrorLog.Println(tag+": Error marshalling req body  ", err)
 		return "", err
 	}
-	req, err := http.NewRequest("POST", reqUrl, bytes.NewBuffer(reqBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", reqUrl, bytes.NewBuffer(reqBody))
 	if err != nil {
 		util.ErrorLog.Println(tag+": Error creating req  ", err)
 		return "", err
@@ -219,38 +390,36 @@ func (s *spotifyService) AddTracksToPlaylist(accessToken string, playlistId stri
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", authHeader)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := s.execute(req)
 	if err != nil {
 		util.ErrorLog.Println(tag+": Error executing request", err)
 		return "", err
 	}
-	defer resp.Body.Close()
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		util.ErrorLog.Println(tag+": Error decoding response body", err)
+	var snapshot responses.SnapshotResponse
+	if err := handleResponse(resp, &snapshot); err != nil {
+		util.ErrorLog.Println(tag+": Spotify responded with an error ", err)
 		return "", err
 	}
-	snapshotId := string(body)
-	return snapshotId, nil
+	return snapshot.SnapshotId, nil
 }
 
-func (s *spotifyService) CreatePlaylist(accessToken string, userId string, name string, desc string) (*models.Playlist, error) {
+func (s *spotifyService) CreatePlaylist(ctx context.Context, accessToken string, userId string, details models.PlaylistDetails) (*models.Playlist, error) {
 	var tag = "SPOTIFY_SERVICE_CREATE_PLAYLIST"
 	reqUrl := s.spotifyBaseWebApi + "/users/" + userId + "/playlists"
 	authHeader := "Bearer " + accessToken
 	payload := map[string]interface{}{
-		"name":        name,
-		"description": desc,
-		"public":      false,
+		"name":          details.Name,
+		"description":   details.Description,
+		"public":        details.Public,
+		"collaborative": details.Collaborative,
 	}
 	reqBody, err := json.Marshal(payload)
 	if err != nil {
 		util.ErrorLog.Println(tag+": Error marshalling req body  ", err)
 		return nil, err
 	}
-	req, err := http.NewRequest("POST", reqUrl, bytes.NewBuffer(reqBody))
+	req, err := http.NewRequestWithContext(ctx, "POST", reqUrl, bytes.NewBuffer(reqBody))
 	if err != nil {
 		util.ErrorLog.Println(tag+": Error creating req  ", err)
 		return nil, err
@@ -258,129 +427,186 @@ func (s *spotifyService) CreatePlaylist(accessToken string, userId string, name
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Authorization", authHeader)
 
-	client := &http.Client{}
-	resp, err := client.Do(req)
+	resp, err := s.execute(req)
 	if err != nil {
 		util.ErrorLog.Println(tag+": Error executing request", err)
 		return nil, err
 	}
-	defer resp.Body.Close()
-
-	// error handling strat, check status code at this point, if not success
-	// return nill plus error object with descriptive message
-	//  // Check the response status code
-	//  if resp.StatusCode == http.StatusOK {
-	// 	fmt.Println("POST request was successful!")
-	// } else {
-	// 	fmt.Println("POST request failed with status code:", resp.StatusCode)
-	// }
-
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		util.ErrorLog.Println(tag+": Error decoding response body", err)
-		return nil, err
-	}
 
 	var playlist models.Playlist
-	err = json.Unmarshal(body, &playlist)
-	if err != nil {
-		util.ErrorLog.Println(tag+": Error unmarshalling res body ", err)
+	if err := handleResponse(resp, &playlist); err != nil {
+		util.ErrorLog.Println(tag+": Spotify responded with an error ", err)
 		return nil, err
 	}
 
 	return &playlist, nil
 }
 
-func (s *spotifyService) GetUserTopItems(accessToken string, entityType string) (*responses.TopItemsResponse, error) {
+func (s *spotifyService) GetUserTopItems(ctx context.Context, accessToken string, entityType string, options TopItemsOptions) (*responses.TopItemsResponse, error) {
 	var tag = "SPOTIFY_SERVICE_GET_USER_TOP_ITEMS"
+	if err := options.validate(); err != nil {
+		util.ErrorLog.Println(tag+": invalid options", err)
+		return nil, err
+	}
 	requestBaseUrl := s.spotifyBaseWebApi + "/me/top/" + entityType
-	queryParams := url.Values{}
-	queryParams.Set("time_range", "short_term")
-	queryParams.Set("limit", "50")
-	fullUrl := fmt.Sprintf("%s?%s", requestBaseUrl, queryParams.Encode())
+	fullUrl := fmt.Sprintf("%s?%s", requestBaseUrl, options.queryParams().Encode())
 
 	authHeader := "Bearer " + accessToken
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", fullUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", fullUrl, nil)
 
 	if err != nil {
 		util.ErrorLog.Println(tag+": Error creating request", err)
 		return nil, err
 	}
 	req.Header.Set("Authorization", authHeader)
-	resp, err := client.Do(req)
+	resp, err := s.execute(req)
 	if err != nil {
 		util.ErrorLog.Println(tag+": Error executing request", err)
 		return nil, err
 	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		util.ErrorLog.Println(tag+": Error decoding response body", err)
-		return nil, err
-	}
 	var topItems responses.TopItemsResponse
-	err = json.Unmarshal(body, &topItems)
-	if err != nil {
-		util.ErrorLog.Println(tag+": Error unmarshalling res body ", err)
+	if err := handleResponse(resp, &topItems); err != nil {
+		util.ErrorLog.Println(tag+": Spotify responded with an error ", err)
 		return nil, err
 	}
 
 	return &topItems, nil
 }
 
-func (s *spotifyService) GetRecommendations(accessToken string, config models.RecommendationProfile) (*responses.RecommendationsResponse, error) {
+// GetAllUserTopItems follows the paging links of the user's top items starting at
+// options.Offset, returning at most maxItems items, or every item when maxItems is zero.
+// options.Limit is used as the page size
+func (s *spotifyService) GetAllUserTopItems(ctx context.Context, accessToken string, entityType string, options TopItemsOptions, maxItems int) ([]models.Item, error) {
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	firstUrl := fmt.Sprintf("%s/me/top/%s?%s", s.spotifyBaseWebApi, entityType, options.queryParams().Encode())
+	return fetchAll[models.Item](ctx, s, accessToken, firstUrl, maxItems)
+}
+
+// GetPlaylistItems returns the tracks in a playlist, following paging links up to maxItems
+func (s *spotifyService) GetPlaylistItems(ctx context.Context, accessToken string, playlistId string, maxItems int) ([]models.PlaylistTrack, error) {
+	queryParams := url.Values{}
+	queryParams.Set("limit", "100")
+	firstUrl := fmt.Sprintf("%s/playlists/%s/tracks?%s", s.spotifyBaseWebApi, url.PathEscape(playlistId), queryParams.Encode())
+	return fetchAll[models.PlaylistTrack](ctx, s, accessToken, firstUrl, maxItems)
+}
+
+// GetSavedTracks returns the tracks in the user's library, following paging links up to maxItems
+func (s *spotifyService) GetSavedTracks(ctx context.Context, accessToken string, maxItems int) ([]models.SavedTrack, error) {
+	queryParams := url.Values{}
+	queryParams.Set("limit", "50")
+	firstUrl := fmt.Sprintf("%s/me/tracks?%s", s.spotifyBaseWebApi, queryParams.Encode())
+	return fetchAll[models.SavedTrack](ctx, s, accessToken, firstUrl, maxItems)
+}
+
+// GetRecentlyPlayed returns the user's recently played tracks, newest first.
+// The endpoint is cursor based but still links to the next page, so it pages the same way
+func (s *spotifyService) GetRecentlyPlayed(ctx context.Context, accessToken string, maxItems int) ([]models.PlayHistory, error) {
+	queryParams := url.Values{}
+	queryParams.Set("limit", "50")
+	firstUrl := fmt.Sprintf("%s/me/player/recently-played?%s", s.spotifyBaseWebApi, queryParams.Encode())
+	return fetchAll[models.PlayHistory](ctx, s, accessToken, firstUrl, maxItems)
+}
+
+func (s *spotifyService) GetRecommendations(ctx context.Context, accessToken string, config models.RecommendationProfile) (*responses.RecommendationsResponse, error) {
 	var tag = "SPOTIFY_SERVICE_GET_RECOMMENDATIONS"
 	requestBaseUrl := s.spotifyBaseWebApi + "/recommendations"
 	queryParams := url.Values{}
-	queryParams.Set("seed_artists", strings.Join(config.SeedArtists, ","))
-	queryParams.Set("seed_tracks", strings.Join(config.SeedTracks, ","))
+	if len(config.SeedArtists) != 0 {
+		queryParams.Set("seed_artists", strings.Join(config.SeedArtists, ","))
+	}
+	if len(config.SeedTracks) != 0 {
+		queryParams.Set("seed_tracks", strings.Join(config.SeedTracks, ","))
+	}
+	if len(config.SeedGenres) != 0 {
+		queryParams.Set("seed_genres", strings.Join(config.SeedGenres, ","))
+	}
 	queryParams.Set("limit", strconv.FormatInt(int64(config.Limit), 10))
-	queryParams.Set("target_acousticness", strconv.FormatFloat(float64(config.Acousticness), 'f', -1, 32))
-	queryParams.Set("target_danceability", strconv.FormatFloat(float64(config.Danceability), 'f', -1, 32))
-	queryParams.Set("target_energy", strconv.FormatFloat(float64(config.Energy), 'f', -1, 32))
-	queryParams.Set("target_instrumentalness", strconv.FormatFloat(float64(config.Instrumentalness), 'f', -1, 32))
-	queryParams.Set("target_liveness", strconv.FormatFloat(float64(config.Liveness), 'f', -1, 32))
-	queryParams.Set("target_tempo", strconv.FormatFloat(float64(config.Tempo), 'f', -1, 32))
-	queryParams.Set("target_valence", strconv.FormatFloat(float64(config.Valence), 'f', -1, 32))
+	// only the constraints the profile sets are sent, leaving spotify free on the rest
+	for attribute, attributeRange := range config.Attributes() {
+		bounds := map[string]*float32{
+			"min_":    attributeRange.Min,
+			"max_":    attributeRange.Max,
+			"target_": attributeRange.Target,
+		}
+		for prefix, bound := range bounds {
+			if bound != nil {
+				queryParams.Set(prefix+attribute, strconv.FormatFloat(float64(*bound), 'f', -1, 32))
+			}
+		}
+	}
 	fullUrl := fmt.Sprintf("%s?%s", requestBaseUrl, queryParams.Encode())
 
 	authHeader := "Bearer " + accessToken
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", fullUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", fullUrl, nil)
 
 	if err != nil {
 		util.ErrorLog.Println(tag+": Error creating request", err)
 		return nil, err
 	}
 	req.Header.Set("Authorization", authHeader)
-	resp, err := client.Do(req)
+	resp, err := s.execute(req)
 	if err != nil {
 		util.ErrorLog.Println(tag+": Error executing request", err)
 		return nil, err
 	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		util.ErrorLog.Println(tag+": Error decoding response body", err)
-		return nil, err
-	}
 	var recommendations responses.RecommendationsResponse
-	err = json.Unmarshal(body, &recommendations)
-	if err != nil {
-		util.ErrorLog.Println(tag+": Error unmarshalling res body ", err)
+	if err := handleResponse(resp, &recommendations); err != nil {
+		util.ErrorLog.Println(tag+": Spotify responded with an error ", err)
 		return nil, err
 	}
 
 	return &recommendations, nil
 }
 
-func (s *spotifyService) GetTracksAudioFeatures(trackIds []string, accessToken string) (*responses.TracksAudioFeatures, error) {
+// GetTracksAudioFeatures retrieves audio features in batches of at most 100 ids, running a
+// few batches at a time. Features are returned in the order of trackIds. When some batches
+// fail the features of the successful ones are returned along with an
+// ApplicationPartialFailureError listing which ids were and were not retrieved
+func (s *spotifyService) GetTracksAudioFeatures(ctx context.Context, trackIds []string, accessToken string) (*responses.TracksAudioFeatures, error) {
 	var tag = "SPOTIFY_SERVICE_GET_TRACK_AUDIO_FEATURES"
-	if len(trackIds) > 100 {
-		util.ErrorLog.Println(tag + ": track ids cannot be more than 100")
-		return nil, errors.New("track ids cannot be more than 100 in length")
-	}
+	batches := chunk(trackIds, audioFeaturesBatchSize)
+	results := make([][]responses.Features, len(batches))
+	errs := make([]error, len(batches))
+
+	var wg sync.WaitGroup
+	semaphore := make(chan struct{}, audioFeaturesConcurrency)
+	for index, batch := range batches {
+		wg.Add(1)
+		go func(index int, batch []string) {
+			defer wg.Done()
+			// batches still waiting for a slot when the caller gives up are not sent at all
+			select {
+			case semaphore <- struct{}{}:
+			case <-ctx.Done():
+				errs[index] = mapContextError(ctx.Err())
+				return
+			}
+			defer func() { <-semaphore }()
+			features, err := s.getAudioFeaturesBatch(ctx, batch, accessToken)
+			if err != nil {
+				errs[index] = err
+				return
+			}
+			results[index] = features.AudioFeatures
+		}(index, batch)
+	}
+	wg.Wait()
+
+	merged := responses.TracksAudioFeatures{AudioFeatures: []responses.Features{}}
+	for _, features := range results {
+		merged.AudioFeatures = append(merged.AudioFeatures, features...)
+	}
+	if err := partialFailure("retrieving audio features", batches, errs); err != nil {
+		util.ErrorLog.Println(tag+": ", err)
+		return &merged, err
+	}
+	return &merged, nil
+}
+
+func (s *spotifyService) getAudioFeaturesBatch(ctx context.Context, trackIds []string, accessToken string) (*responses.TracksAudioFeatures, error) {
+	var tag = "SPOTIFY_SERVICE_GET_AUDIO_FEATURES_BATCH"
 	requestUrl := s.spotifyBaseWebApi + "/audio-features"
 	commaSeperatedIdString := strings.Join(trackIds, ",")
 	queryParams := url.Values{}
@@ -388,94 +614,166 @@ func (s *spotifyService) GetTracksAudioFeatures(trackIds []string, accessToken s
 	fullUrl := fmt.Sprintf("%s?%s", requestUrl, queryParams.Encode())
 
 	authHeader := "Bearer " + accessToken
-	client := &http.Client{}
-	req, err := http.NewRequest("GET", fullUrl, nil)
+	req, err := http.NewRequestWithContext(ctx, "GET", fullUrl, nil)
 
 	if err != nil {
 		util.ErrorLog.Println(tag+": Error creating request", err)
 		return nil, err
 	}
 	req.Header.Set("Authorization", authHeader)
-	resp, err := client.Do(req)
+	resp, err := s.execute(req)
 	if err != nil {
 		util.ErrorLog.Println(tag+": Error executing request", err)
 		return nil, err
 	}
 
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		util.ErrorLog.Println(tag+": Error decoding response body", err)
-		return nil, err
-	}
-
 	var features responses.TracksAudioFeatures
-	err = json.Unmarshal(body, &features)
-	if err != nil {
-		util.ErrorLog.Println(tag+": Error unmarshalling res body ", err)
+	if err := handleResponse(resp, &features); err != nil {
+		util.ErrorLog.Println(tag+": Spotify responded with an error ", err)
 		return nil, err
 	}
 
 	return &features, nil
 }
 
-func (s *spotifyService) GetAccessTokenWithAuthCode(authCode string) (*responses.AccessTokenResponse, error) {
+// GetAccessTokenWithAuthCode exchanges an authorization code for an access and refresh token.
+// When a code verifier is provided the exchange is done using PKCE, in which case
+// the client id is sent in the body and the client secret is never used
+func (s *spotifyService) GetAccessTokenWithAuthCode(ctx context.Context, authCode string, codeVerifier string) (*responses.AccessTokenResponse, error) {
 	tag := "SPOTIFY_SERVICE_GET_ACCESS_TOKEN_WITH_AUTH_CODE"
 
+	clientId := s.clientId
+
 	formData := url.Values{}
 	formData.Set("grant_type", "authorization_code")
 	formData.Set("code", authCode)
 	formData.Set("redirect_uri", s.spotifyRedirectUri)
+	if len(codeVerifier) != 0 {
+		formData.Set("client_id", clientId)
+		formData.Set("code_verifier", codeVerifier)
+	}
+
+	payload := strings.NewReader(formData.Encode())
+
+	url := s.spotifyBaseAuthUrl + "/api/token"
+	req, err := http.NewRequestWithContext(ctx, "POST", url, payload)
+	if err != nil {
+		util.ErrorLog.Println("Error creating request", err)
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	if len(codeVerifier) == 0 {
+		clientSecret := s.clientSecret
+		authString := clientId + ":" + clientSecret
+		encodedAuthString := base64.StdEncoding.EncodeToString([]byte(authString))
+		req.Header.Set("Authorization", "Basic "+encodedAuthString)
+	}
+
+	resp, err := s.execute(req)
+	if err != nil {
+		util.ErrorLog.Println("Error executing request", err)
+		return nil, err
+	}
+	var accessTokenResponse responses.AccessTokenResponse
+	if err := handleResponse(resp, &accessTokenResponse); err != nil {
+		util.ErrorLog.Println(tag+": Spotify responded with an error ", err)
+		return nil, err
+	}
+	return &accessTokenResponse, nil
+}
+
+func (s *spotifyService) RefreshAccessToken(ctx context.Context, refreshToken string) (*responses.AccessTokenResponse, error) {
+	tag := "SPOTIFY_SERVICE_REFRESH_ACCESS_TOKEN"
+
+	formData := url.Values{}
+	formData.Set("grant_type", "refresh_token")
+	formData.Set("refresh_token", refreshToken)
 
 	payload := strings.NewReader(formData.Encode())
 
-	clientId := config.EnvSpotifyClientId()
-	clientSecret := config.EnvSpotifyClientSecret()
+	clientId := s.clientId
+	clientSecret := s.clientSecret
 	authString := clientId + ":" + clientSecret
 
 	encodedAuthString := base64.StdEncoding.EncodeToString([]byte(authString))
 
 	authHeader := "Basic " + encodedAuthString
-	client := &http.Client{}
 	url := s.spotifyBaseAuthUrl + "/api/token"
-	req, err := http.NewRequest("POST", url, payload)
+	req, err := http.NewRequestWithContext(ctx, "POST", url, payload)
 	if err != nil {
-		util.ErrorLog.Println("Error creating request", err)
+		util.ErrorLog.Println(tag+": Error creating request", err)
 		return nil, err
 	}
 	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
 	req.Header.Set("Authorization", authHeader)
 
-	resp, err := client.Do(req)
+	resp, err := s.execute(req)
 	if err != nil {
-		util.ErrorLog.Println("Error executing request", err)
+		util.ErrorLog.Println(tag+": Error executing request", err)
 		return nil, err
 	}
-	defer resp.Body.Close()
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		util.ErrorLog.Println("Error decoding response body", err)
+	var accessTokenResponse responses.AccessTokenResponse
+	if err := handleResponse(resp, &accessTokenResponse); err != nil {
+		util.ErrorLog.Println(tag+": Spotify responded with an error ", err)
 		return nil, err
 	}
+	return &accessTokenResponse, nil
+}
 
-	if resp.StatusCode == 400 {
-		var spotifyErrorRes responses.SpotifyAuthErrorReponse
-		err = json.Unmarshal(body, &spotifyErrorRes)
-		if err != nil {
-			util.ErrorLog.Println(tag+": Error unmarshalling res body ", err)
-			return nil, err
-		}
-		var authError = util.ApplicationAuthError{
-			Message: spotifyErrorRes.ErrorDescription,
-		}
-		return nil, authError
+func (s *spotifyService) Search(ctx context.Context, accessToken string, query string, types []string, limit int, offset int) (*responses.SearchResponse, error) {
+	var tag = "SPOTIFY_SERVICE_SEARCH"
+	requestBaseUrl := s.spotifyBaseWebApi + "/search"
+	queryParams := url.Values{}
+	queryParams.Set("q", query)
+	queryParams.Set("type", strings.Join(types, ","))
+	queryParams.Set("limit", strconv.Itoa(limit))
+	queryParams.Set("offset", strconv.Itoa(offset))
+	fullUrl := fmt.Sprintf("%s?%s", requestBaseUrl, queryParams.Encode())
+
+	authHeader := "Bearer " + accessToken
+	req, err := http.NewRequestWithContext(ctx, "GET", fullUrl, nil)
+
+	if err != nil {
+		util.ErrorLog.Println(tag+": Error creating request", err)
+		return nil, err
+	}
+	req.Header.Set("Authorization", authHeader)
+	resp, err := s.execute(req)
+	if err != nil {
+		util.ErrorLog.Println(tag+": Error executing request", err)
+		return nil, err
+	}
+	var searchResponse responses.SearchResponse
+	if err := handleResponse(resp, &searchResponse); err != nil {
+		util.ErrorLog.Println(tag+": Spotify responded with an error ", err)
+		return nil, err
 	}
 
-	var accessTokenResponse responses.AccessTokenResponse
-	err = json.Unmarshal(body, &accessTokenResponse)
+	return &searchResponse, nil
+}
+
+func (s *spotifyService) GetArtist(ctx context.Context, accessToken string, artistId string) (*models.Item, error) {
+	var tag = "SPOTIFY_SERVICE_GET_ARTIST"
+	reqUrl := s.spotifyBaseWebApi + "/artists/" + url.PathEscape(artistId)
+
+	authHeader := "Bearer " + accessToken
+	req, err := http.NewRequestWithContext(ctx, "GET", reqUrl, nil)
+
 	if err != nil {
-		util.ErrorLog.Println("Error unmarshalling res body ", err)
+		util.ErrorLog.Println(tag+": Error creating request", err)
 		return nil, err
 	}
-	return &accessTokenResponse, nil
+	req.Header.Set("Authorization", authHeader)
+	resp, err := s.execute(req)
+	if err != nil {
+		util.ErrorLog.Println(tag+": Error executing request", err)
+		return nil, err
+	}
+	var artist models.Item
+	if err := handleResponse(resp, &artist); err != nil {
+		util.ErrorLog.Println(tag+": Spotify responded with an error ", err)
+		return nil, err
+	}
+
+	return &artist, nil
 }
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"net/http"
+	"strings"
+	"time"
+)
+
+const defaultSpotifyAuthBaseURL = "https://accounts.spotify.com"
+const defaultSpotifyAPIBaseURL = "https://api.spotify.com/v1"
+const defaultUserAgent = "playlist-gen/1.0"
+const defaultRequestTimeout = 10 * time.Second
+
+// requests per second sent to spotify across the whole app, and how many may go out at once
+const defaultRateLimit = 10
+const defaultRateLimitBurst = 10
+
+// consecutive failures that open a circuit breaker, and how long it stays open before probing
+const defaultBreakerThreshold = 5
+const defaultBreakerOpenTimeout = 30 * time.Second
+
+// SpotifyServiceOption configures a SpotifyService created with NewSpotifyService
+type SpotifyServiceOption func(*spotifyService)
+
+// WithClientCredentials sets the client id and secret the app authenticates to spotify with
+func WithClientCredentials(clientId string, clientSecret string) SpotifyServiceOption {
+	return func(s *spotifyService) {
+		s.clientId = clientId
+		s.clientSecret = clientSecret
+	}
+}
+
+// WithAuthBaseURL sets the base url of the accounts service, used for token requests
+func WithAuthBaseURL(baseUrl string) SpotifyServiceOption {
+	return func(s *spotifyService) {
+		s.spotifyBaseAuthUrl = strings.TrimSuffix(baseUrl, "/")
+	}
+}
+
+// WithWebAPIBaseURL sets the base url of the web api, including the version path
+func WithWebAPIBaseURL(baseUrl string) SpotifyServiceOption {
+	return func(s *spotifyService) {
+		s.spotifyBaseWebApi = strings.TrimSuffix(baseUrl, "/")
+	}
+}
+
+// WithHTTPClient sets the http client every request is sent through,
+// a nil client leaves the current one in place
+func WithHTTPClient(client *http.Client) SpotifyServiceOption {
+	return func(s *spotifyService) {
+		if client != nil {
+			s.httpClient = client
+		}
+	}
+}
+
+// WithTransport sets the round tripper of the service's http client,
+// other settings of the client are kept
+func WithTransport(transport http.RoundTripper) SpotifyServiceOption {
+	return func(s *spotifyService) {
+		client := http.Client{}
+		if s.httpClient != nil {
+			client = *s.httpClient
+		}
+		client.Transport = transport
+		s.httpClient = &client
+	}
+}
+
+// WithUserAgent sets the User-Agent header sent with every request
+func WithUserAgent(userAgent string) SpotifyServiceOption {
+	return func(s *spotifyService) {
+		s.userAgent = userAgent
+	}
+}
+
+// WithRequestTimeout bounds how long a single request to spotify may take,
+// a timeout of zero disables it
+func WithRequestTimeout(timeout time.Duration) SpotifyServiceOption {
+	return func(s *spotifyService) {
+		s.requestTimeout = timeout
+	}
+}
+
+// WithRetryPolicy sets how failed requests are retried
+func WithRetryPolicy(policy RetryPolicy) SpotifyServiceOption {
+	return func(s *spotifyService) {
+		s.retryPolicy = policy
+	}
+}
+
+// WithRateLimit caps the requests per second the service sends to spotify,
+// shared across every goroutine using the service
+func WithRateLimit(requestsPerSecond float64, burst int) SpotifyServiceOption {
+	return func(s *spotifyService) {
+		s.rateLimit = requestsPerSecond
+		s.rateLimitBurst = burst
+	}
+}
+
+// WithCircuitBreaker sets how many consecutive failures open the breaker of an endpoint
+// group and how long it stays open before a probe request is let through
+func WithCircuitBreaker(failureThreshold int, openTimeout time.Duration) SpotifyServiceOption {
+	return func(s *spotifyService) {
+		s.breakerThreshold = failureThreshold
+		s.breakerOpenTimeout = openTimeout
+	}
+}
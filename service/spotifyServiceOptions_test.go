@@ -0,0 +1,149 @@
+package service
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+// countingTransport counts the requests sent through it before handing them on
+type countingTransport struct {
+	requests int32
+	next     http.RoundTripper
+}
+
+func (t *countingTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	atomic.AddInt32(&t.requests, 1)
+	return t.next.RoundTrip(req)
+}
+
+// newSpotifyStandIn serves the accounts and web api endpoints the tests call,
+// failing the test when a request doesn't look the way spotify expects it
+func newSpotifyStandIn(t *testing.T) *httptest.Server {
+	mux := http.NewServeMux()
+	mux.HandleFunc("/accounts/api/token", func(w http.ResponseWriter, r *http.Request) {
+		if r.Method != http.MethodPost {
+			t.Errorf("token request method = %s, want POST", r.Method)
+		}
+		if err := r.ParseForm(); err != nil {
+			t.Fatalf("could not parse token request: %v", err)
+		}
+		if r.Header.Get("User-Agent") != "options-test" {
+			t.Errorf("User-Agent = %q, want options-test", r.Header.Get("User-Agent"))
+		}
+		switch r.PostForm.Get("grant_type") {
+		case "client_credentials":
+			clientId, clientSecret, ok := r.BasicAuth()
+			if !ok || clientId != "test-client" || clientSecret != "test-secret" {
+				t.Errorf("basic auth = %q:%q, want test-client:test-secret", clientId, clientSecret)
+			}
+		case "authorization_code":
+			// a PKCE exchange identifies the client in the body and never sends the secret
+			if _, _, ok := r.BasicAuth(); ok {
+				t.Error("PKCE exchange sent basic auth")
+			}
+			if r.PostForm.Get("client_id") != "test-client" || r.PostForm.Get("code_verifier") != "verifier" {
+				t.Errorf("PKCE form = %v", r.PostForm)
+			}
+			if r.PostForm.Get("redirect_uri") != "http://localhost/callback" {
+				t.Errorf("redirect_uri = %q", r.PostForm.Get("redirect_uri"))
+			}
+		default:
+			t.Errorf("unexpected grant_type %q", r.PostForm.Get("grant_type"))
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"access_token": "token-" + r.PostForm.Get("grant_type"),
+			"token_type":   "Bearer",
+			"expires_in":   3600,
+		})
+	})
+	mux.HandleFunc("/v1/me", func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Authorization") != "Bearer user-token" {
+			w.WriteHeader(http.StatusUnauthorized)
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"error": map[string]interface{}{"status": 401, "message": "Invalid access token"},
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"id":           "user-1",
+			"display_name": "Test User",
+		})
+	})
+	return httptest.NewServer(mux)
+}
+
+func newTestSpotifyService(server *httptest.Server, opts ...SpotifyServiceOption) SpotifyService {
+	opts = append([]SpotifyServiceOption{
+		WithClientCredentials("test-client", "test-secret"),
+		WithAuthBaseURL(server.URL + "/accounts/"),
+		WithWebAPIBaseURL(server.URL + "/v1"),
+		WithHTTPClient(server.Client()),
+		WithUserAgent("options-test"),
+		WithRetryPolicy(RetryPolicy{}),
+	}, opts...)
+	return NewSpotifyService("http://localhost/callback", opts...)
+}
+
+func TestSpotifyServiceOptionsTokenExchange(t *testing.T) {
+	server := newSpotifyStandIn(t)
+	defer server.Close()
+	transport := &countingTransport{next: server.Client().Transport}
+	spotify := newTestSpotifyService(server, WithTransport(transport))
+	ctx := context.Background()
+
+	clientToken, err := spotify.GetAccessTokenWithClientCredentials(ctx)
+	if err != nil {
+		t.Fatalf("GetAccessTokenWithClientCredentials: %v", err)
+	}
+	if clientToken.AccessToken != "token-client_credentials" {
+		t.Errorf("access token = %q, want token-client_credentials", clientToken.AccessToken)
+	}
+
+	userToken, err := spotify.GetAccessTokenWithAuthCode(ctx, "code", "verifier")
+	if err != nil {
+		t.Fatalf("GetAccessTokenWithAuthCode: %v", err)
+	}
+	if userToken.AccessToken != "token-authorization_code" {
+		t.Errorf("access token = %q, want token-authorization_code", userToken.AccessToken)
+	}
+	if got := atomic.LoadInt32(&transport.requests); got != 2 {
+		t.Errorf("requests through transport = %d, want 2", got)
+	}
+}
+
+func TestSpotifyServiceOptionsWebAPI(t *testing.T) {
+	server := newSpotifyStandIn(t)
+	defer server.Close()
+	spotify := newTestSpotifyService(server)
+	ctx := context.Background()
+
+	profile, err := spotify.GetUserProfile(ctx, "user-token")
+	if err != nil {
+		t.Fatalf("GetUserProfile: %v", err)
+	}
+	if profile.Id != "user-1" || profile.DisplayName != "Test User" {
+		t.Errorf("profile = %+v", profile)
+	}
+
+	if _, err := spotify.GetUserProfile(ctx, "bad-token"); err == nil {
+		t.Error("GetUserProfile with a bad token returned no error")
+	}
+}
+
+func TestWithTransportWithoutClient(t *testing.T) {
+	server := newSpotifyStandIn(t)
+	defer server.Close()
+	transport := &countingTransport{next: server.Client().Transport}
+	spotify := newTestSpotifyService(server, WithHTTPClient(nil), WithTransport(transport))
+
+	if _, err := spotify.GetUserProfile(context.Background(), "user-token"); err != nil {
+		t.Fatalf("GetUserProfile: %v", err)
+	}
+	if got := atomic.LoadInt32(&transport.requests); got != 1 {
+		t.Errorf("requests through transport = %d, want 1", got)
+	}
+}
@@ -0,0 +1,66 @@
+package service
+
+import (
+	"context"
+	"mofe64/playlistGen/util"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+// how long a ticket can be used to open or reopen a job's event stream
+const StreamTicketTTL = 5 * time.Minute
+
+const streamTicketKeyPrefix = "job_stream_ticket:"
+
+// StreamTicketStore hands out tickets that authorize reading the event stream of one job.
+// Browsers' EventSource cannot send an Authorization header, so the ticket travels in the
+// stream url instead of the user's access token. Tickets are not consumed when used, since
+// EventSource reconnects to the same url when the connection drops, they expire instead
+type StreamTicketStore interface {
+	IssueTicket(ctx context.Context, userId string, jobId string) (string, error)
+	VerifyTicket(ctx context.Context, ticket string, userId string, jobId string) error
+}
+
+type streamTicketStore struct {
+	redis *redis.Client
+}
+
+func NewStreamTicketStore(redisClient *redis.Client) StreamTicketStore {
+	return &streamTicketStore{
+		redis: redisClient,
+	}
+}
+
+func (s *streamTicketStore) IssueTicket(ctx context.Context, userId string, jobId string) (string, error) {
+	tag := "STREAM_TICKET_STORE_ISSUE_TICKET"
+	ticket, err := generateTokenId()
+	if err != nil {
+		return "", err
+	}
+	if err := s.redis.Set(ctx, streamTicketKeyPrefix+ticket, userId+":"+jobId, StreamTicketTTL).Err(); err != nil {
+		util.ErrorLog.Println(tag+": Could not set ticket in redis", err.Error())
+		return "", err
+	}
+	return ticket, nil
+}
+
+// VerifyTicket checks the ticket was issued for the user's job and has not expired
+func (s *streamTicketStore) VerifyTicket(ctx context.Context, ticket string, userId string, jobId string) error {
+	tag := "STREAM_TICKET_STORE_VERIFY_TICKET"
+	if len(ticket) == 0 {
+		return util.ApplicationAuthError{Message: "Missing ticket parameter"}
+	}
+	val, err := s.redis.Get(ctx, streamTicketKeyPrefix+ticket).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return util.ApplicationAuthError{Message: "Invalid or expired ticket"}
+		}
+		util.ErrorLog.Println(tag+": Could not retrieve ticket from redis", err.Error())
+		return err
+	}
+	if val != userId+":"+jobId {
+		return util.ApplicationAuthError{Message: "Ticket not valid for this job"}
+	}
+	return nil
+}
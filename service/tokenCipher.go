@@ -0,0 +1,167 @@
+package service
+
+import (
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"mofe64/playlistGen/data/models"
+)
+
+const dataKeySize = 32
+
+// TokenCipher encrypts the spotify tokens held in a session using envelope encryption.
+// Every session gets its own random data key which encrypts the tokens, the data key is
+// in turn encrypted with one of the configured key encryption keys. Rotating to a new
+// key encryption key only requires re-wrapping the data key, not the tokens themselves
+type TokenCipher interface {
+	Seal(session models.Session) (models.Session, error)
+	Open(session models.Session) (models.Session, error)
+	Rotate(session models.Session) (models.Session, error)
+	ActiveKeyId() string
+}
+
+type tokenCipher struct {
+	keys        map[string][]byte
+	activeKeyId string
+}
+
+func NewTokenCipher(keys map[string][]byte, activeKeyId string) TokenCipher {
+	return &tokenCipher{
+		keys:        keys,
+		activeKeyId: activeKeyId,
+	}
+}
+
+func (t *tokenCipher) ActiveKeyId() string {
+	return t.activeKeyId
+}
+
+// Seal returns a copy of the session with its tokens encrypted under a fresh data key
+func (t *tokenCipher) Seal(session models.Session) (models.Session, error) {
+	if session.IsEncrypted() {
+		return session, nil
+	}
+	dataKey := make([]byte, dataKeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return session, err
+	}
+	wrappedKey, err := t.wrapKey(dataKey, t.activeKeyId)
+	if err != nil {
+		return session, err
+	}
+	accessToken, err := encrypt(dataKey, []byte(session.AccessToken), []byte("access_token"))
+	if err != nil {
+		return session, err
+	}
+	refreshToken, err := encrypt(dataKey, []byte(session.RefreshToken), []byte("refresh_token"))
+	if err != nil {
+		return session, err
+	}
+	session.AccessToken = accessToken
+	session.RefreshToken = refreshToken
+	session.KeyId = t.activeKeyId
+	session.DataKey = wrappedKey
+	return session, nil
+}
+
+// Open returns a copy of the session with its tokens decrypted. Sessions stored before
+// encryption was introduced are returned unchanged
+func (t *tokenCipher) Open(session models.Session) (models.Session, error) {
+	if !session.IsEncrypted() {
+		return session, nil
+	}
+	dataKey, err := t.unwrapKey(session.DataKey, session.KeyId)
+	if err != nil {
+		return session, err
+	}
+	accessToken, err := decrypt(dataKey, session.AccessToken, []byte("access_token"))
+	if err != nil {
+		return session, err
+	}
+	refreshToken, err := decrypt(dataKey, session.RefreshToken, []byte("refresh_token"))
+	if err != nil {
+		return session, err
+	}
+	session.AccessToken = string(accessToken)
+	session.RefreshToken = string(refreshToken)
+	session.KeyId = ""
+	session.DataKey = ""
+	return session, nil
+}
+
+// Rotate re-wraps the session's data key with the active key, plaintext sessions are sealed
+func (t *tokenCipher) Rotate(session models.Session) (models.Session, error) {
+	if !session.IsEncrypted() {
+		return t.Seal(session)
+	}
+	if session.KeyId == t.activeKeyId {
+		return session, nil
+	}
+	dataKey, err := t.unwrapKey(session.DataKey, session.KeyId)
+	if err != nil {
+		return session, err
+	}
+	wrappedKey, err := t.wrapKey(dataKey, t.activeKeyId)
+	if err != nil {
+		return session, err
+	}
+	session.KeyId = t.activeKeyId
+	session.DataKey = wrappedKey
+	return session, nil
+}
+
+func (t *tokenCipher) wrapKey(dataKey []byte, keyId string) (string, error) {
+	key, ok := t.keys[keyId]
+	if !ok {
+		return "", errors.New("unknown token encryption key " + keyId)
+	}
+	return encrypt(key, dataKey, []byte(keyId))
+}
+
+func (t *tokenCipher) unwrapKey(wrappedKey string, keyId string) ([]byte, error) {
+	key, ok := t.keys[keyId]
+	if !ok {
+		return nil, errors.New("unknown token encryption key " + keyId)
+	}
+	return decrypt(key, wrappedKey, []byte(keyId))
+}
+
+// encrypt seals plaintext with AES-GCM and returns base64(nonce || ciphertext)
+func encrypt(key []byte, plaintext []byte, additionalData []byte) (string, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return "", err
+	}
+	nonce := make([]byte, gcm.NonceSize())
+	if _, err := rand.Read(nonce); err != nil {
+		return "", err
+	}
+	sealed := gcm.Seal(nonce, nonce, plaintext, additionalData)
+	return base64.StdEncoding.EncodeToString(sealed), nil
+}
+
+func decrypt(key []byte, encoded string, additionalData []byte) ([]byte, error) {
+	gcm, err := newGCM(key)
+	if err != nil {
+		return nil, err
+	}
+	sealed, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil, err
+	}
+	if len(sealed) < gcm.NonceSize() {
+		return nil, errors.New("encrypted value is too short")
+	}
+	nonce, ciphertext := sealed[:gcm.NonceSize()], sealed[gcm.NonceSize():]
+	return gcm.Open(nil, nonce, ciphertext, additionalData)
+}
+
+func newGCM(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+	return cipher.NewGCM(block)
+}
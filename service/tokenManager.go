@@ -0,0 +1,98 @@
+package service
+
+import (
+	"context"
+	"mofe64/playlistGen/data/models"
+	"mofe64/playlistGen/util"
+	"time"
+
+	"golang.org/x/sync/singleflight"
+)
+
+// refresh access tokens this long before spotify would reject them
+const tokenRefreshWindow = 5 * time.Minute
+
+// upper bound on refreshing and persisting a session
+const sessionRefreshTimeout = 20 * time.Second
+
+// TokenManager hands out spotify sessions that are safe to use.
+// When the stored access token is about to expire it is exchanged for a new one
+// using the stored refresh token and the rotated tokens are persisted
+type TokenManager interface {
+	GetValidSession(ctx context.Context, userId string) (*models.Session, error)
+}
+
+type tokenManager struct {
+	spotifyService SpotifyService
+	sessionStore   SessionStore
+	// collapses concurrent refreshes for the same user into a single call to spotify
+	refreshGroup singleflight.Group
+}
+
+func NewTokenManager(spotifyService SpotifyService, sessionStore SessionStore) TokenManager {
+	return &tokenManager{
+		spotifyService: spotifyService,
+		sessionStore:   sessionStore,
+	}
+}
+
+func (t *tokenManager) GetValidSession(ctx context.Context, userId string) (*models.Session, error) {
+	session, err := t.sessionStore.GetSession(ctx, userId)
+	if err != nil {
+		return nil, err
+	}
+	if !session.ExpiresWithin(tokenRefreshWindow) {
+		return session, nil
+	}
+
+	/**
+		The refresh is shared by every request for this user that is waiting on it,
+		so it must not be cancelled just because the request that started it went away
+	**/
+	result, err, _ := t.refreshGroup.Do(userId, func() (interface{}, error) {
+		refreshCtx, cancel := context.WithTimeout(context.Background(), sessionRefreshTimeout)
+		defer cancel()
+		return t.refreshSession(refreshCtx, userId, *session)
+	})
+	if err != nil {
+		return nil, err
+	}
+	return result.(*models.Session), nil
+}
+
+func (t *tokenManager) refreshSession(ctx context.Context, userId string, session models.Session) (*models.Session, error) {
+	tag := "TOKEN_MANAGER_REFRESH_SESSION"
+	if len(session.RefreshToken) == 0 {
+		return nil, util.ApplicationAuthError{
+			Message: "Session has no refresh token, re-authorization required",
+		}
+	}
+
+	util.InfoLog.Println(tag + ": refreshing access token for user " + userId)
+	resp, err := t.spotifyService.RefreshAccessToken(ctx, session.RefreshToken)
+	if err != nil {
+		util.ErrorLog.Println(tag+": could not refresh access token", err.Error())
+		return nil, err
+	}
+
+	refreshed := models.Session{
+		AccessToken:  resp.AccessToken,
+		TokenType:    resp.TokenType,
+		Scope:        resp.Scope,
+		RefreshToken: resp.RefreshToken,
+		ExpiresIn:    resp.ExpiresIn,
+		IssuedAt:     time.Now(),
+	}
+	// spotify does not always rotate the refresh token, keep the old one when it doesn't
+	if len(refreshed.RefreshToken) == 0 {
+		refreshed.RefreshToken = session.RefreshToken
+	}
+	if len(refreshed.Scope) == 0 {
+		refreshed.Scope = session.Scope
+	}
+
+	if err := t.sessionStore.SaveSession(ctx, userId, refreshed); err != nil {
+		return nil, err
+	}
+	return &refreshed, nil
+}
@@ -0,0 +1,104 @@
+package service
+
+import (
+	"context"
+	"mofe64/playlistGen/util"
+	"strconv"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+const deniedTokenKeyPrefix = "denied_token:"
+const revokedBeforeKeyPrefix = "revoked_before:"
+
+// TokenRevocationStore tracks tokens that must no longer be accepted even though
+// their signature and expiry are still valid. Single tokens are deny-listed by id
+// until they would have expired anyway, and every token issued to a user before a
+// point in time can be revoked at once
+type TokenRevocationStore interface {
+	RevokeToken(ctx context.Context, claims *AuthClaims) error
+	ConsumeToken(ctx context.Context, claims *AuthClaims) (bool, error)
+	RevokeAllTokens(ctx context.Context, userId string) error
+	IsRevoked(ctx context.Context, claims *AuthClaims) (bool, error)
+}
+
+type tokenRevocationStore struct {
+	redis *redis.Client
+}
+
+func NewTokenRevocationStore(redisClient *redis.Client) TokenRevocationStore {
+	return &tokenRevocationStore{
+		redis: redisClient,
+	}
+}
+
+func (t *tokenRevocationStore) RevokeToken(ctx context.Context, claims *AuthClaims) error {
+	tag := "TOKEN_REVOCATION_STORE_REVOKE_TOKEN"
+	ttl := time.Until(claims.ExpiresAt.Time)
+	if ttl <= 0 {
+		// token has already expired, nothing to deny
+		return nil
+	}
+	if err := t.redis.Set(ctx, deniedTokenKeyPrefix+claims.ID, claims.Subject, ttl).Err(); err != nil {
+		util.ErrorLog.Println(tag+": could not deny-list token", err.Error())
+		return err
+	}
+	return nil
+}
+
+// ConsumeToken deny-lists a single use token and reports whether this call was the one
+// that did it. The check and the deny-listing are one redis command, so when the same
+// token is presented concurrently only one caller gets to use it
+func (t *tokenRevocationStore) ConsumeToken(ctx context.Context, claims *AuthClaims) (bool, error) {
+	tag := "TOKEN_REVOCATION_STORE_CONSUME_TOKEN"
+	ttl := time.Until(claims.ExpiresAt.Time)
+	if ttl <= 0 {
+		// an expired token can't be used, whether or not it was deny-listed
+		return false, nil
+	}
+	consumed, err := t.redis.SetNX(ctx, deniedTokenKeyPrefix+claims.ID, claims.Subject, ttl).Result()
+	if err != nil {
+		util.ErrorLog.Println(tag+": could not deny-list token", err.Error())
+		return false, err
+	}
+	return consumed, nil
+}
+
+func (t *tokenRevocationStore) RevokeAllTokens(ctx context.Context, userId string) error {
+	tag := "TOKEN_REVOCATION_STORE_REVOKE_ALL_TOKENS"
+	revokedBefore := strconv.FormatInt(time.Now().UnixMilli(), 10)
+	// no token issued before now outlives a refresh token, so the marker can expire with them
+	if err := t.redis.Set(ctx, revokedBeforeKeyPrefix+userId, revokedBefore, refreshTokenTTL).Err(); err != nil {
+		util.ErrorLog.Println(tag+": could not revoke tokens", err.Error())
+		return err
+	}
+	return nil
+}
+
+func (t *tokenRevocationStore) IsRevoked(ctx context.Context, claims *AuthClaims) (bool, error) {
+	tag := "TOKEN_REVOCATION_STORE_IS_REVOKED"
+	denied, err := t.redis.Exists(ctx, deniedTokenKeyPrefix+claims.ID).Result()
+	if err != nil {
+		util.ErrorLog.Println(tag+": could not check deny-list", err.Error())
+		return false, err
+	}
+	if denied > 0 {
+		return true, nil
+	}
+
+	val, err := t.redis.Get(ctx, revokedBeforeKeyPrefix+claims.Subject).Result()
+	if err != nil {
+		if err == redis.Nil {
+			return false, nil
+		}
+		util.ErrorLog.Println(tag+": could not check revocation marker", err.Error())
+		return false, err
+	}
+	revokedBefore, err := strconv.ParseInt(val, 10, 64)
+	if err != nil {
+		util.ErrorLog.Println(tag+": invalid revocation marker", err.Error())
+		return false, err
+	}
+	return claims.IssuedAt.Time.Before(time.UnixMilli(revokedBefore)), nil
+}
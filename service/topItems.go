@@ -0,0 +1,171 @@
+package service
+
+import (
+	"context"
+	"mofe64/playlistGen/data/models"
+	"mofe64/playlistGen/util"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+)
+
+// Time ranges spotify computes a user's top items over
+const (
+	TimeRangeShortTerm  = "short_term"  // roughly the last 4 weeks
+	TimeRangeMediumTerm = "medium_term" // roughly the last 6 months
+	TimeRangeLongTerm   = "long_term"   // several years of listening
+)
+
+const (
+	defaultTopItemsLimit = 50
+	maxTopItemsLimit     = 50
+)
+
+// TopItemsOptions controls which of a user's top items are retrieved.
+// Zero values fall back to the short term range and a limit of 50
+type TopItemsOptions struct {
+	TimeRange string
+	Limit     int
+	Offset    int
+}
+
+func (o TopItemsOptions) validate() error {
+	switch o.TimeRange {
+	case "", TimeRangeShortTerm, TimeRangeMediumTerm, TimeRangeLongTerm:
+	default:
+		return util.ApplicationError{Message: "Unknown time range " + o.TimeRange, Status: 400}
+	}
+	if o.Limit < 0 || o.Limit > maxTopItemsLimit {
+		return util.ApplicationError{Message: "Top items limit must be between 1 and " + strconv.Itoa(maxTopItemsLimit), Status: 400}
+	}
+	if o.Offset < 0 {
+		return util.ApplicationError{Message: "Top items offset cannot be negative", Status: 400}
+	}
+	return nil
+}
+
+func (o TopItemsOptions) queryParams() url.Values {
+	timeRange := o.TimeRange
+	if len(timeRange) == 0 {
+		timeRange = TimeRangeShortTerm
+	}
+	limit := o.Limit
+	if limit == 0 {
+		limit = defaultTopItemsLimit
+	}
+	queryParams := url.Values{}
+	queryParams.Set("time_range", timeRange)
+	queryParams.Set("limit", strconv.Itoa(limit))
+	if o.Offset > 0 {
+		queryParams.Set("offset", strconv.Itoa(o.Offset))
+	}
+	return queryParams
+}
+
+// TasteBlend weighs how much each time range contributes to the blended top items.
+// Weights are relative to each other, a range with a weight of zero is not fetched
+type TasteBlend struct {
+	ShortTerm  float64 `json:"short_term"`
+	MediumTerm float64 `json:"medium_term"`
+	LongTerm   float64 `json:"long_term"`
+}
+
+// Named blends clients can ask for instead of passing weights
+const (
+	TasteBlendRightNow  = "right_now"
+	TasteBlendBalanced  = "balanced"
+	TasteBlendNostalgia = "nostalgia"
+)
+
+var tasteBlendPresets = map[string]TasteBlend{
+	TasteBlendRightNow:  {ShortTerm: 1},
+	TasteBlendBalanced:  {ShortTerm: 0.5, MediumTerm: 0.3, LongTerm: 0.2},
+	TasteBlendNostalgia: {LongTerm: 1},
+}
+
+// DefaultTasteBlend matches what playlists were built from before blending existed
+var DefaultTasteBlend = tasteBlendPresets[TasteBlendRightNow]
+
+// TasteBlendPreset returns the blend registered under name
+func TasteBlendPreset(name string) (TasteBlend, bool) {
+	blend, ok := tasteBlendPresets[name]
+	return blend, ok
+}
+
+// Validate reports whether the weights can be used to blend top items
+func (b TasteBlend) Validate() error {
+	if b.ShortTerm < 0 || b.MediumTerm < 0 || b.LongTerm < 0 {
+		return util.ApplicationError{Message: "Taste blend weights cannot be negative", Status: 400}
+	}
+	if b.ShortTerm+b.MediumTerm+b.LongTerm == 0 {
+		return util.ApplicationError{Message: "At least one taste blend weight must be above zero", Status: 400}
+	}
+	return nil
+}
+
+func (b TasteBlend) weights() map[string]float64 {
+	weights := map[string]float64{}
+	if b.ShortTerm > 0 {
+		weights[TimeRangeShortTerm] = b.ShortTerm
+	}
+	if b.MediumTerm > 0 {
+		weights[TimeRangeMediumTerm] = b.MediumTerm
+	}
+	if b.LongTerm > 0 {
+		weights[TimeRangeLongTerm] = b.LongTerm
+	}
+	return weights
+}
+
+// BlendTopItems fetches the user's top items for every time range in the blend and merges
+// them into a single ranking. An item scores the weight of each range it appears in,
+// scaled by how high it ranks there, so items the user loved across ranges rise to the top
+func BlendTopItems(ctx context.Context, spotify SpotifyService, accessToken string, entityType string, blend TasteBlend) ([]models.Item, error) {
+	tag := "BLEND_TOP_ITEMS"
+	if err := blend.Validate(); err != nil {
+		return nil, err
+	}
+	weights := blend.weights()
+
+	var wg sync.WaitGroup
+	var mutex sync.Mutex
+	var operationError error
+	itemsByRange := map[string][]models.Item{}
+	for timeRange := range weights {
+		wg.Add(1)
+		go func(timeRange string) {
+			defer wg.Done()
+			data, err := spotify.GetUserTopItems(ctx, accessToken, entityType, TopItemsOptions{TimeRange: timeRange})
+			mutex.Lock()
+			defer mutex.Unlock()
+			if err != nil {
+				util.ErrorLog.Println(tag+": could not retrieve "+timeRange+" top "+entityType, err.Error())
+				operationError = err
+				return
+			}
+			itemsByRange[timeRange] = data.Items
+		}(timeRange)
+	}
+	wg.Wait()
+	if operationError != nil {
+		return nil, operationError
+	}
+
+	scores := map[string]float64{}
+	items := []models.Item{}
+	// walk the ranges in a fixed order so ties keep a stable order
+	for _, timeRange := range []string{TimeRangeShortTerm, TimeRangeMediumTerm, TimeRangeLongTerm} {
+		rangeItems := itemsByRange[timeRange]
+		for rank, item := range rangeItems {
+			if _, seen := scores[item.Id]; !seen {
+				items = append(items, item)
+			}
+			scores[item.Id] += weights[timeRange] * (1 - float64(rank)/float64(len(rangeItems)))
+		}
+	}
+	sort.SliceStable(items, func(i, j int) bool {
+		return scores[items[i].Id] > scores[items[j].Id]
+	})
+	return items, nil
+}
@@ -1,6 +1,9 @@
 package util
 
-import "sync"
+import (
+	"sync"
+	"time"
+)
 
 // Define a global variable to hold the singleton instance of ApplicationContext.
 var instance *applicationContext = nil
@@ -12,12 +15,16 @@ var once sync.Once
 type ApplicationContext interface {
 	SetAccessToken(t string)
 	GetAcessToken() string
+	SetAccessTokenWithExpiry(t string, expiresAt time.Time)
+	GetAccessTokenExpiry() time.Time
+	GetAccessTokenWithExpiry() (string, time.Time)
 }
 
 // Define the struct that implements the ApplicationContext interface.
 type applicationContext struct {
-	accessToken  string
-	sync.RWMutex // Use sync.RWMutex to handle concurrent access to the accessToken field.
+	accessToken          string
+	accessTokenExpiresAt time.Time
+	sync.RWMutex         // Use sync.RWMutex to handle concurrent access to the accessToken field.
 }
 
 // SetAccessToken sets the access token in the singleton instance.
@@ -34,6 +41,30 @@ func (a *applicationContext) GetAcessToken() string {
 	return a.accessToken
 }
 
+// SetAccessTokenWithExpiry sets the access token and the time it expires at in one step,
+// so readers never see a new token paired with an old expiry.
+func (a *applicationContext) SetAccessTokenWithExpiry(token string, expiresAt time.Time) {
+	a.Lock()
+	defer a.Unlock()
+	a.accessToken = token
+	a.accessTokenExpiresAt = expiresAt
+}
+
+// GetAccessTokenExpiry retrieves the time the access token expires at, the zero time if unknown.
+func (a *applicationContext) GetAccessTokenExpiry() time.Time {
+	a.RLock()
+	defer a.RUnlock()
+	return a.accessTokenExpiresAt
+}
+
+// GetAccessTokenWithExpiry retrieves the access token and the time it expires at in one step,
+// so callers never pair a token with the expiry of another.
+func (a *applicationContext) GetAccessTokenWithExpiry() (string, time.Time) {
+	a.RLock()
+	defer a.RUnlock()
+	return a.accessToken, a.accessTokenExpiresAt
+}
+
 // GetAccessToken retrieves the access token from the singleton instance.
 func GetApplicationContextInstance() ApplicationContext {
 	// Use sync.Once to ensure that the instance is created only once, even in the presence of concurrent calls.
@@ -1,25 +1,156 @@
 package util
 
+import (
+	"errors"
+	"time"
+)
+
+// Errors raised while talking to spotify carry the http status spotify responded with,
+// spotify's error code when it sent one, and whether repeating the request may succeed
+
 type ApplicationError struct {
-	Message string
+	Message   string
+	Status    int
+	Code      string
+	Retryable bool
 }
 
 type ApplicationAuthError struct {
-	Message string
+	Message   string
+	Status    int
+	Code      string
+	Retryable bool
+}
+
+type ApplicationForbiddenError struct {
+	Message   string
+	Status    int
+	Code      string
+	Retryable bool
 }
 
 type ApplicationRateLimitError struct {
-	Message string
+	Message   string
+	Status    int
+	Code      string
+	Retryable bool
+	// how long spotify asked us to wait before trying again, zero when not specified
+	RetryAfter time.Duration
+}
+
+// ApplicationUpstreamError reports spotify failing on its side with a 5xx status
+type ApplicationUpstreamError struct {
+	Message   string
+	Status    int
+	Code      string
+	Retryable bool
 }
 
 func (e ApplicationAuthError) Error() string {
 	return e.Message
 }
 
+func (e ApplicationForbiddenError) Error() string {
+	return e.Message
+}
+
 func (e ApplicationRateLimitError) Error() string {
 	return e.Message
 }
 
+func (e ApplicationUpstreamError) Error() string {
+	return e.Message
+}
+
 func (e ApplicationError) Error() string {
 	return e.Message
 }
+
+type ApplicationNotFoundError struct {
+	Message   string
+	Status    int
+	Code      string
+	Retryable bool
+}
+
+func (e ApplicationNotFoundError) Error() string {
+	return e.Message
+}
+
+// ApplicationTimeoutError reports that an operation ran out of time before it completed,
+// either because its deadline passed or because a per request timeout was hit
+type ApplicationTimeoutError struct {
+	Message string
+}
+
+func (e ApplicationTimeoutError) Error() string {
+	return e.Message
+}
+
+// IsRetryable reports whether the operation that returned err may succeed if repeated
+func IsRetryable(err error) bool {
+	var applicationError ApplicationError
+	var authError ApplicationAuthError
+	var forbiddenError ApplicationForbiddenError
+	var notFoundError ApplicationNotFoundError
+	var rateLimitError ApplicationRateLimitError
+	var upstreamError ApplicationUpstreamError
+	var timeoutError ApplicationTimeoutError
+	switch {
+	case errors.As(err, &applicationError):
+		return applicationError.Retryable
+	case errors.As(err, &authError):
+		return authError.Retryable
+	case errors.As(err, &forbiddenError):
+		return forbiddenError.Retryable
+	case errors.As(err, &notFoundError):
+		return notFoundError.Retryable
+	case errors.As(err, &rateLimitError):
+		return rateLimitError.Retryable
+	case errors.As(err, &upstreamError):
+		return upstreamError.Retryable
+	case errors.As(err, &timeoutError):
+		return true
+	}
+	return false
+}
+
+// WasRejected reports whether the request that returned err was turned away before spotify
+// acted on it, so repeating a request that is not idempotent cannot apply it twice
+func WasRejected(err error) bool {
+	var partialError ApplicationPartialFailureError
+	var rateLimitError ApplicationRateLimitError
+	var unavailableError ApplicationUnavailableError
+	if errors.As(err, &partialError) {
+		return false
+	}
+	return errors.As(err, &rateLimitError) || errors.As(err, &unavailableError)
+}
+
+// ApplicationUnavailableError reports that a request was not sent because spotify has been
+// failing and the circuit breaker in front of it is open
+type ApplicationUnavailableError struct {
+	Message string
+}
+
+func (e ApplicationUnavailableError) Error() string {
+	return e.Message
+}
+
+// ApplicationPartialFailureError reports that a batched operation only partly succeeded.
+// Succeeded and Failed hold the input items of the batches that went through and of the
+// ones that did not, Cause is the first error a failed batch returned
+type ApplicationPartialFailureError struct {
+	Message   string
+	Succeeded []string
+	Failed    []string
+	Cause     error
+}
+
+func (e ApplicationPartialFailureError) Error() string {
+	return e.Message
+}
+
+func (e ApplicationPartialFailureError) Unwrap() error {
+	return e.Cause
+}
@@ -1,10 +1,12 @@
 package util
 
 import (
+	"errors"
 	"log"
 	"mofe64/playlistGen/data/responses"
 	"net/http"
 	"os"
+	"strconv"
 	"strings"
 	"time"
 
@@ -43,6 +45,64 @@ func GenerateBadRequestResponse(c *gin.Context, message string) {
 	})
 }
 
+// GenerateErrorResponse responds with the http status matching the type of err.
+// Application errors carrying a 4xx status, such as a request spotify rejected as
+// invalid, keep that status. Any other error is reported as an internal error
+// without exposing its message
+func GenerateErrorResponse(c *gin.Context, err error) {
+	GenerateErrorResponseWithData(c, err, gin.H{})
+}
+
+// GenerateErrorResponseWithData responds like GenerateErrorResponse, with data describing
+// what the failed operation did get done added alongside the error
+func GenerateErrorResponseWithData(c *gin.Context, err error, data gin.H) {
+	status := http.StatusInternalServerError
+	message := "Something went wrong"
+	detail := "Internal Error, please try again"
+
+	var authError ApplicationAuthError
+	var forbiddenError ApplicationForbiddenError
+	var notFoundError ApplicationNotFoundError
+	var rateLimitError ApplicationRateLimitError
+	var upstreamError ApplicationUpstreamError
+	var timeoutError ApplicationTimeoutError
+	var unavailableError ApplicationUnavailableError
+	var applicationError ApplicationError
+	switch {
+	case errors.As(err, &authError):
+		status, message, detail = http.StatusUnauthorized, "Unauthorized", authError.Message
+	case errors.As(err, &forbiddenError):
+		status, message, detail = http.StatusForbidden, "Forbidden", forbiddenError.Message
+	case errors.As(err, &notFoundError):
+		status, message, detail = http.StatusNotFound, "Not found", notFoundError.Message
+	case errors.As(err, &rateLimitError):
+		status, message, detail = http.StatusTooManyRequests, "Too many requests", rateLimitError.Message
+		if rateLimitError.RetryAfter > 0 {
+			c.Header("Retry-After", strconv.Itoa(int(rateLimitError.RetryAfter.Seconds())))
+		}
+	case errors.As(err, &upstreamError):
+		status, message, detail = http.StatusBadGateway, "Spotify is having trouble", upstreamError.Message
+	case errors.As(err, &timeoutError):
+		status, message, detail = http.StatusGatewayTimeout, "Request timed out", timeoutError.Message
+	case errors.As(err, &unavailableError):
+		status, message, detail = http.StatusServiceUnavailable, "Service unavailable", unavailableError.Message
+	case errors.As(err, &applicationError) && applicationError.Status >= 400 && applicationError.Status < 500:
+		status, message, detail = applicationError.Status, http.StatusText(applicationError.Status), applicationError.Message
+	}
+
+	responseData := gin.H{"error": detail}
+	for key, value := range data {
+		responseData[key] = value
+	}
+	c.JSON(status, responses.APIResponse{
+		Status:    status,
+		Success:   false,
+		Message:   message,
+		Timestamp: time.Now(),
+		Data:      responseData,
+	})
+}
+
 func ExtractDuplicateKeyInfo(err error) string {
 	errMsg := err.Error()
 	startIdx := strings.Index(errMsg, "dup key: {")